@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/lfkeitel/inca-tool/devices"
+)
+
+const (
+	defaultConfigFile    = "inca.conf"
+	defaultInventoryFile = "devices.conf"
+)
+
+var configFile string // flag
+
+// toolConfig is the configuration of Inca Tool itself as opposed to a task or inventory
+type toolConfig struct {
+	maxDevicesPerRun int
+	inventoryHTTP    string
+}
+
+// loadToolConfig reads the tool config file. The file may be in any format supported for
+// variable files. A missing file is only an error if it was given with the -config flag.
+func loadToolConfig(filename string) (*toolConfig, error) {
+	config := &toolConfig{}
+	if _, err := os.Stat(filename); os.IsNotExist(err) && filename == defaultConfigFile {
+		return config, nil
+	}
+
+	settings, err := devices.ParseVarsFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	for key, value := range settings {
+		// Settings may be written with spaces or underscores
+		switch strings.Replace(strings.ToLower(key), "_", " ", -1) {
+		case "max devices per run":
+			max, err := strconv.Atoi(value)
+			if err != nil || max < 0 {
+				return nil, fmt.Errorf("Setting %s in %s must be a positive number", key, filename)
+			}
+			config.maxDevicesPerRun = max
+		case "inventory http":
+			config.inventoryHTTP = value
+		default:
+			return nil, fmt.Errorf("Unknown setting %s in %s", key, filename)
+		}
+	}
+	return config, nil
+}
@@ -1,110 +1,445 @@
 package devices
 
 import (
-	"errors"
 	"fmt"
+	"sort"
+	"strconv"
+	"strings"
 )
 
 // Device represents a device
 type Device struct {
 	Name     string
 	settings map[string]string
+	literal  map[string]bool
 	Groups   []string
 	list     *DeviceList
+	origin   lineOrigin
 }
 
 // DeviceList is a list of device groups
 type DeviceList struct {
-	Groups  map[string]*Group
-	Devices map[string]*Device
+	Groups       map[string]*Group
+	Devices      map[string]*Device
+	taskSettings map[string]string
+	overrides    map[string]string
+	source       *DeviceList
+	disabled     []*Device
+	issues       []LintIssue
 }
 
-// Group is a collection of devices
+// Group is a collection of devices and other groups
 type Group struct {
 	Name     string
 	Devices  []*Device
+	Children []string
 	list     *DeviceList
 	settings map[string]string
+	literal  map[string]bool
+	parents  []string
+	depth    int
+	priority int
+	origin   lineOrigin
 }
 
 // GetGlobal returns a setting from the global device settings
 func (d *DeviceList) GetGlobal(name string) string {
+	if d.Groups["global"] != nil && d.Groups["global"].literal[name] {
+		return d.getGlobal(name)
+	}
+	setting, _ := resolveSetting(name, d.getGlobal(name))
+	return setting
+}
+
+func (d *DeviceList) getGlobal(name string) string {
+	setting, _ := d.lookupGlobal(name)
+	return setting
+}
+
+// lookupGlobal returns a global setting and if it was set
+func (d *DeviceList) lookupGlobal(name string) (string, bool) {
 	if _, ok := d.Groups["global"]; !ok {
-		return ""
+		return "", false
 	}
 	data, ok := d.Groups["global"].settings[name]
-	if !ok {
-		return ""
-	}
-	return data
+	return data, ok
 }
 
-// GetSetting returns the setting name from the group settings. It will also look for global settings if a
-// group specific one isn't given. Returns empty string if not found.
+// SetTaskSettings sets the settings given by a task file. Task settings override global settings
+// but are overridden by group and device settings.
+func (d *DeviceList) SetTaskSettings(settings map[string]string) {
+	d.taskSettings = settings
+}
+
+// SetOverrides sets settings that take precedence over every other setting such as
+// those given on the command line.
+func (d *DeviceList) SetOverrides(settings map[string]string) {
+	d.overrides = settings
+}
+
+// GetSetting returns the setting name from the group settings. It will also look for task and global
+// settings if a group specific one isn't given. Returns empty string if not found.
 func (g *Group) GetSetting(name string) string {
-	setting := g.list.GetGlobal(name)
-	ns, _ := g.settings[name]
-	if ns != "" {
-		setting = ns
+	setting, _ := g.LookupSetting(name)
+	return setting
+}
+
+// LookupSetting is the same as GetSetting but returns an error if the setting
+// refers to an external source that couldn't be resolved.
+func (g *Group) LookupSetting(name string) (string, error) {
+	setting, literal := g.lookupSetting(name)
+	if literal {
+		return setting, nil
 	}
+	return resolveSetting(name, setting)
+}
+
+func (g *Group) getSetting(name string) string {
+	setting, _ := g.lookupSetting(name)
 	return setting
 }
 
+// lookupSetting returns the unresolved value of the setting name and if it's literal
+func (g *Group) lookupSetting(name string) (string, bool) {
+	setting, literal := g.list.getGlobal(name), false
+	if global, ok := g.list.Groups["global"]; ok {
+		literal = global.literal[name]
+	}
+	if ns, ok := g.list.taskSettings[name]; ok {
+		setting, literal = ns, false
+	}
+	for _, parent := range append(g.ancestors(), g) {
+		if ns, ok := parent.settings[name]; ok {
+			setting, literal = ns, parent.literal[name]
+		}
+	}
+	return setting, literal
+}
+
+// Parents returns the names of the groups that contain the group
+func (g *Group) Parents() []string {
+	return g.parents
+}
+
+// AllDevices returns the devices in the group and all of its child groups
+func (g *Group) AllDevices() []*Device {
+	seenGroups := make(map[string]bool)
+	seenDevices := make(map[string]bool)
+	var devices []*Device
+
+	var collect func(*Group)
+	collect = func(group *Group) {
+		if seenGroups[group.Name] {
+			return
+		}
+		seenGroups[group.Name] = true
+		for _, device := range group.Devices {
+			if !seenDevices[device.Name] {
+				seenDevices[device.Name] = true
+				devices = append(devices, device)
+			}
+		}
+		for _, child := range group.Children {
+			collect(g.list.Groups[child])
+		}
+	}
+	collect(g)
+	return devices
+}
+
+// ancestors returns the parents of the group and their parents in the order their
+// settings are applied, the same as Device.groupOrder.
+func (g *Group) ancestors() []*Group {
+	var groups []*Group
+	for _, parent := range g.parents {
+		groups = appendGroupTree(groups, g.list.Groups[parent])
+	}
+	sortGroups(groups, nil)
+	return groups
+}
+
+// appendGroupTree appends g and all of its ancestors to groups if not already present
+func appendGroupTree(groups []*Group, g *Group) []*Group {
+	for _, group := range groups {
+		if group == g {
+			return groups
+		}
+	}
+	groups = append(groups, g)
+	for _, parent := range g.parents {
+		groups = appendGroupTree(groups, g.list.Groups[parent])
+	}
+	return groups
+}
+
+// sortGroups sorts groups in the order their settings are applied. Groups are sorted from the
+// least to the most nested, then from the lowest to the highest priority. Then groups in direct
+// are sorted after the others. Finally groups are sorted by name so the order never depends
+// on the order of the inventory file.
+func sortGroups(groups []*Group, direct map[*Group]bool) {
+	sort.Slice(groups, func(i, j int) bool {
+		a, b := groups[i], groups[j]
+		if a.depth != b.depth {
+			return a.depth < b.depth
+		}
+		if a.priority != b.priority {
+			return a.priority < b.priority
+		}
+		if direct[a] != direct[b] {
+			return direct[b]
+		}
+		return a.Name < b.Name
+	})
+}
+
 // GetSetting returns the setting name from the device's settings.
-// The group and global setting will be consulted per the order of precedence.
+// The group, task, and global setting will be consulted per the order of precedence.
 // Returns empty string if not found.
 func (d *Device) GetSetting(name string) string {
-	setting := d.list.GetGlobal(name)
-	for _, g := range d.Groups {
-		ns := d.list.Groups[g].GetSetting(name)
-		if ns != "" {
-			setting = ns
+	setting, _ := d.LookupSetting(name)
+	return setting
+}
+
+// LookupSetting is the same as GetSetting but returns an error if the setting
+// refers to an external source that couldn't be resolved.
+func (d *Device) LookupSetting(name string) (string, error) {
+	setting, _, literal := d.lookupSetting(name)
+	if literal {
+		return setting, nil
+	}
+	return resolveSetting(name, setting)
+}
+
+// IsExternal returns if the setting name refers to an external source such as a vault
+// or command. The values of these settings are usually secret and shouldn't be logged.
+func (d *Device) IsExternal(name string) bool {
+	setting, _, literal := d.lookupSetting(name)
+	return !literal && isExternal(setting)
+}
+
+func (d *Device) getSetting(name string) string {
+	setting, _ := d.SettingSource(name)
+	return setting
+}
+
+// SettingSource returns the unresolved value of the setting name and where it was set.
+// The source is "global", "task", "group <name>", "device", or "override" for settings
+// given on the command line. The source is empty if the setting isn't set.
+func (d *Device) SettingSource(name string) (string, string) {
+	setting, source, _ := d.lookupSetting(name)
+	return setting, source
+}
+
+// lookupSetting is the same as SettingSource but also returns if the value is literal
+func (d *Device) lookupSetting(name string) (string, string, bool) {
+	setting, source, literal := "", "", false
+	if global, ok := d.list.Groups["global"]; ok {
+		if ns, ok := global.settings[name]; ok {
+			setting, source, literal = ns, "global", global.literal[name]
 		}
 	}
-	ns, _ := d.settings[name]
-	if ns != "" {
-		setting = ns
+	if ns, ok := d.list.taskSettings[name]; ok {
+		setting, source, literal = ns, "task", false
 	}
-	return setting
+	for _, g := range d.groupOrder() {
+		if ns, ok := g.settings[name]; ok {
+			setting, source, literal = ns, "group "+g.Name, g.literal[name]
+		}
+	}
+	if ns, ok := d.settings[name]; ok {
+		setting, source, literal = ns, "device", d.literal[name]
+	}
+	if ns, ok := d.list.overrides[name]; ok {
+		setting, source, literal = ns, "override", false
+	}
+	return setting, source, literal
 }
 
-// GetSettings returns all settings as a map from a Group.
-func (g *Group) GetSettings() map[string]string {
-	return g.settings
+// IsProtected returns if the device has the protected setting. Tasks aren't run on
+// protected devices unless forced. A value that isn't true or false counts as protected.
+func (d *Device) IsProtected() bool {
+	protected, err := d.Flag("protected")
+	return protected || err != nil
 }
 
-// GetSettings returns all settings as a map from a Device.
-func (d *Device) GetSettings() map[string]string {
-	return d.settings
+// Flag returns the value of a true or false setting such as protected. An unset setting is
+// false. An error is returned if the value isn't true or false.
+func (d *Device) Flag(name string) (bool, error) {
+	value := d.getSetting(name)
+	if value == "" {
+		return false, nil
+	}
+	flag, err := parseFlag(value)
+	if err != nil {
+		return false, fmt.Errorf("Setting %s must be true or false, not %s", name, value)
+	}
+	return flag, nil
 }
 
-// Filter filters a device list to the groups or devices specified
-func Filter(dl *DeviceList, filter []string) (*DeviceList, error) {
-	devices := &DeviceList{
-		Groups:  make(map[string]*Group),
-		Devices: make(map[string]*Device),
+// parseFlag parses a true or false setting. Yes, no, on, and off are accepted along with
+// the values accepted by strconv.ParseBool.
+func parseFlag(value string) (bool, error) {
+	switch strings.ToLower(value) {
+	case "yes", "on":
+		return true, nil
+	case "no", "off":
+		return false, nil
 	}
+	return strconv.ParseBool(value)
+}
 
-	for _, term := range filter {
-		// Check for a group
-		if _, exists := dl.Groups[term]; exists {
-			if term == "global" {
-				return nil, errors.New("Global group cannot be used.\n")
-			}
-			devices.Groups[term] = dl.Groups[term]
-			// Add devices from group to Devices field
-			for _, d := range devices.Groups[term].Devices {
-				devices.Devices[d.Name] = d
+// IsDisabled returns if the device has the disabled setting. Disabled devices are
+// left out of every device selection. A value that isn't true or false counts as disabled.
+func (d *Device) IsDisabled() bool {
+	disabled, err := d.Flag("disabled")
+	return disabled || err != nil
+}
+
+// DisabledReason returns why the device is disabled as given by the disabled_reason setting
+func (d *Device) DisabledReason() string {
+	return d.GetSetting("disabled_reason")
+}
+
+// Tags returns the sorted tags of the device. Unlike other settings, the tags setting
+// is a comma separated list that's combined from every place it's set.
+func (d *Device) Tags() []string {
+	lists := []string{d.list.getGlobal("tags"), d.list.taskSettings["tags"]}
+	for _, g := range d.groupOrder() {
+		lists = append(lists, g.settings["tags"])
+	}
+	lists = append(lists, d.settings["tags"], d.list.overrides["tags"])
+
+	seen := make(map[string]bool)
+	var tags []string
+	for _, list := range lists {
+		for _, tag := range strings.Split(list, ",") {
+			tag = strings.TrimSpace(tag)
+			if tag != "" && !seen[tag] {
+				seen[tag] = true
+				tags = append(tags, tag)
 			}
-			continue
 		}
-		// Check device
-		if _, exists := dl.Devices[term]; exists {
-			devices.Devices[term] = dl.Devices[term]
-			continue
+	}
+	sort.Strings(tags)
+	return tags
+}
+
+// SettingNames returns the sorted names of every setting that applies to the device
+func (d *Device) SettingNames() []string {
+	settings := d.getAllSettings()
+	names := make([]string, 0, len(settings))
+	for name := range settings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// groupOrder returns the groups of the device and the groups containing them in the order
+// their settings are applied. See sortGroups for the order.
+func (d *Device) groupOrder() []*Group {
+	var groups []*Group
+	for _, name := range d.Groups {
+		groups = appendGroupTree(groups, d.list.Groups[name])
+	}
+
+	direct := make(map[*Group]bool, len(d.Groups))
+	for _, name := range d.Groups {
+		direct[d.list.Groups[name]] = true
+	}
+	sortGroups(groups, direct)
+	return groups
+}
+
+// GetAllSettings returns every setting that applies to the device. Each value is
+// resolved the same as GetSetting so the order of precedence is respected.
+func (d *Device) GetAllSettings() map[string]string {
+	settings := d.getAllSettings()
+	for k := range settings {
+		settings[k], _ = d.LookupSetting(k)
+	}
+	return settings
+}
+
+// getAllSettings returns every setting that applies to the device without resolving external values
+func (d *Device) getAllSettings() map[string]string {
+	keys := make(map[string]bool)
+	if global, ok := d.list.Groups["global"]; ok {
+		for k := range global.settings {
+			keys[k] = true
 		}
-		return nil, fmt.Errorf("Group or device \"%s\" not found.\n", term)
 	}
+	for k := range d.list.taskSettings {
+		keys[k] = true
+	}
+	for _, g := range d.groupOrder() {
+		for k := range g.settings {
+			keys[k] = true
+		}
+	}
+	for k := range d.settings {
+		keys[k] = true
+	}
+	for k := range d.list.overrides {
+		keys[k] = true
+	}
+
+	settings := make(map[string]string, len(keys))
+	for k := range keys {
+		settings[k] = d.getSetting(k)
+	}
+	return settings
+}
+
+// markLiteral marks every setting in the list as literal. Literal values are used as they're
+// given and never resolved from an external source. This is used for inventories that come
+// from plugins, scripts, and HTTP sources so their output can't run commands or read secrets.
+func (d *DeviceList) markLiteral() {
+	for _, g := range d.Groups {
+		g.literal = literalKeys(g.settings)
+	}
+	for _, device := range d.Devices {
+		device.literal = literalKeys(device.settings)
+	}
+}
+
+// setLiteral sets if the setting key of the group is literal
+func (g *Group) setLiteral(key string, literal bool) {
+	g.literal = setLiteral(g.literal, key, literal)
+}
+
+// setLiteral sets if the setting key of the device is literal
+func (d *Device) setLiteral(key string, literal bool) {
+	d.literal = setLiteral(d.literal, key, literal)
+}
 
-	return devices, nil
+func setLiteral(set map[string]bool, key string, literal bool) map[string]bool {
+	if !literal {
+		delete(set, key)
+		return set
+	}
+	if set == nil {
+		set = make(map[string]bool)
+	}
+	set[key] = true
+	return set
+}
+
+// literalKeys returns a set of every key in settings
+func literalKeys(settings map[string]string) map[string]bool {
+	literal := make(map[string]bool, len(settings))
+	for k := range settings {
+		literal[k] = true
+	}
+	return literal
+}
+
+// GetSettings returns all settings as a map from a Group.
+func (g *Group) GetSettings() map[string]string {
+	return g.settings
+}
+
+// GetSettings returns all settings as a map from a Device.
+func (d *Device) GetSettings() map[string]string {
+	return d.settings
 }
@@ -0,0 +1,659 @@
+package devices
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var groupNameCheckRegex = regexp.MustCompile(`^[\w\- ]+$`)
+
+// Kinds of lines in a document
+const (
+	docOther = iota // Blank lines and comments
+	docInclude
+	docHeader
+	docGlobal
+	docDevice
+	docChild
+)
+
+// Document is an inventory file in the standard format that can be changed and written back.
+// Comments, blank lines, includes, and the order of groups and devices are kept. Only the
+// lines that are changed are rewritten.
+type Document struct {
+	filename string
+	lines    []docLine
+}
+
+// docLine is a line of a document
+type docLine struct {
+	text    string
+	kind    int
+	section string // The group of the section the line is in
+	name    string // The group of a header, the device of a device line, or a child group
+}
+
+// NewDocument returns an empty document. It can be saved with SaveAs.
+func NewDocument() *Document {
+	return &Document{}
+}
+
+// OpenDocument reads an inventory file in the standard format
+func OpenDocument(filename string) (*Document, error) {
+	filename, _ = filepath.Abs(filename)
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	if format := inventoryFormat(filename, data); format != "ini" {
+		return nil, fmt.Errorf("Inventory file %s is %s, only files in the standard format can be edited", filename, strings.ToUpper(format))
+	}
+	return ParseDocument(bytes.NewReader(data), filename)
+}
+
+// ParseDocument reads a document from r. The filename is used to save the document and to
+// resolve includes. It may be empty.
+func ParseDocument(r io.Reader, filename string) (*Document, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	doc := &Document{filename: filename}
+	text := strings.TrimSuffix(string(data), "\n")
+	if text == "" {
+		return doc, nil
+	}
+
+	section := ""
+	children := false
+	for i, text := range strings.Split(text, "\n") {
+		line := docLine{text: text}
+		trimmed := strings.TrimSpace(text)
+
+		switch {
+		case trimmed == "" || trimmed[0] == '#':
+			line.kind = docOther
+		case trimmed[0] == '@':
+			line.kind = docInclude
+		case trimmed[0] == '[':
+			groupLine := groupNameRegex.FindStringSubmatch(trimmed)
+			if len(groupLine) == 0 {
+				return nil, fmt.Errorf("%s: Error defining group\n", lineOrigin{file: filename, line: i + 1})
+			}
+			section = groupLine[1]
+			children = groupLine[2] != ""
+			line.kind = docHeader
+			line.name = section
+		case children:
+			line.kind = docChild
+			line.name = trimmed
+		case section == "global":
+			line.kind = docGlobal
+		case section == "":
+			return nil, fmt.Errorf("%s: All devices must be inside a group\n", lineOrigin{file: filename, line: i + 1})
+		default:
+			line.kind = docDevice
+			line.name = strings.SplitN(trimmed, " ", 2)[0]
+		}
+		line.section = section
+		doc.lines = append(doc.lines, line)
+	}
+	return doc, nil
+}
+
+// Filename returns the file the document was read from or was last saved to
+func (doc *Document) Filename() string {
+	return doc.filename
+}
+
+// String returns the text of the document
+func (doc *Document) String() string {
+	var buf bytes.Buffer
+	for _, line := range doc.lines {
+		buf.WriteString(line.text)
+		buf.WriteByte('\n')
+	}
+	return buf.String()
+}
+
+// WriteTo writes the text of the document to w
+func (doc *Document) WriteTo(w io.Writer) (int64, error) {
+	n, err := io.WriteString(w, doc.String())
+	return int64(n), err
+}
+
+// Save writes the document back to its file. The file is replaced in one step so
+// it's never left half written.
+func (doc *Document) Save() error {
+	if doc.filename == "" {
+		return fmt.Errorf("Document doesn't have a file name, use SaveAs")
+	}
+	return doc.SaveAs(doc.filename)
+}
+
+// SaveAs writes the document to filename and uses it as the document's file from then on
+func (doc *Document) SaveAs(filename string) error {
+	filename, _ = filepath.Abs(filename)
+	mode := os.FileMode(0644)
+	if info, err := os.Stat(filename); err == nil {
+		mode = info.Mode()
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(filename), "."+filepath.Base(filename))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := doc.WriteTo(tmp); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), mode); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), filename); err != nil {
+		return err
+	}
+	doc.filename = filename
+	return nil
+}
+
+// DeviceList parses the document into a device list. Includes and variable directories
+// are relative to the document's file.
+func (doc *Document) DeviceList() (*DeviceList, error) {
+	devices, err := parseINI(strings.NewReader(doc.String()), doc.filename, nil)
+	if err != nil {
+		return nil, err
+	}
+	if doc.filename != "" {
+		if err := loadVarsDirectories(devices, filepath.Dir(doc.filename)); err != nil {
+			return nil, err
+		}
+	}
+	if err := linkGroups(devices); err != nil {
+		return nil, err
+	}
+	return devices, nil
+}
+
+// AddGroup adds a group to the end of the document
+func (doc *Document) AddGroup(name string, settings map[string]string) error {
+	if !groupNameCheckRegex.MatchString(name) {
+		return fmt.Errorf("Invalid group name \"%s\"", name)
+	}
+	if doc.findHeader(name) >= 0 {
+		return fmt.Errorf("Group %s already exists in %s", name, doc.name())
+	}
+	if doc.findDevice(name) >= 0 {
+		return fmt.Errorf("Can't add a group with the same name as a device: %s", name)
+	}
+
+	header := "[" + name + "]"
+	for _, key := range sortedKeys(settings) {
+		setting, err := formatSetting(key, settings[key])
+		if err != nil {
+			return err
+		}
+		header += " " + setting
+	}
+
+	if len(doc.lines) > 0 && strings.TrimSpace(doc.lines[len(doc.lines)-1].text) != "" {
+		doc.lines = append(doc.lines, docLine{kind: docOther})
+	}
+	doc.lines = append(doc.lines, docLine{text: header, kind: docHeader, section: name, name: name})
+	return nil
+}
+
+// AddDevice adds a device to a group. The group is added if it doesn't exist. Settings may
+// only be given the first time a device is added, later declarations add it to more groups.
+func (doc *Document) AddDevice(group, name string, settings map[string]string) error {
+	if name == "" || strings.ContainsAny(name, " \t") || strings.ContainsAny(name[:1], "[#@") {
+		return fmt.Errorf("Invalid device name \"%s\"", name)
+	}
+	if group == "global" {
+		return fmt.Errorf("Devices can't be added to the global group")
+	}
+	if doc.findHeader(name) >= 0 {
+		return fmt.Errorf("Can't add a device with the same name as a group: %s", name)
+	}
+	for _, line := range doc.lines {
+		if line.kind == docDevice && line.section == group && line.name == name {
+			return fmt.Errorf("Device %s is already in group %s", name, group)
+		}
+	}
+	if len(settings) > 0 && doc.findDevice(name) >= 0 {
+		return fmt.Errorf("Device %s is already declared, its settings can only be changed", name)
+	}
+
+	text := name
+	for _, key := range sortedKeys(settings) {
+		setting, err := formatSetting(key, settings[key])
+		if err != nil {
+			return err
+		}
+		text += " " + setting
+	}
+
+	// Devices are added to the end of the last section of the group that isn't a children section
+	header := -1
+	for i, line := range doc.lines {
+		if line.kind == docHeader && line.name == group && !isChildrenHeader(line.text) {
+			header = i
+		}
+	}
+	if header < 0 {
+		if doc.findHeader(group) < 0 {
+			if err := doc.AddGroup(group, nil); err != nil {
+				return err
+			}
+		} else {
+			doc.lines = append(doc.lines, docLine{kind: docOther}, docLine{text: "[" + group + "]", kind: docHeader, section: group, name: group})
+		}
+		header = len(doc.lines) - 1
+	}
+
+	doc.insert(doc.lastContent(header)+1, docLine{text: text, kind: docDevice, section: group, name: name})
+	return nil
+}
+
+// RemoveDevice removes a device from a group, or from every group if group is empty. If
+// the declaration with the device's settings is removed, the settings are moved to the next
+// declaration.
+func (doc *Document) RemoveDevice(name, group string) error {
+	var remove []int
+	for i, line := range doc.lines {
+		if line.kind == docDevice && line.name == name && (group == "" || line.section == group) {
+			remove = append(remove, i)
+		}
+	}
+	if len(remove) == 0 {
+		if i := doc.findDevice(name); i >= 0 {
+			return doc.rangeError(name, i)
+		}
+		if group != "" {
+			return fmt.Errorf("Device %s isn't in group %s in %s", name, group, doc.name())
+		}
+		return fmt.Errorf("Device %s isn't declared in %s", name, doc.name())
+	}
+
+	doc.moveDeviceSettings(name, remove)
+	doc.removeLines(remove)
+	return nil
+}
+
+// RemoveGroup removes every section of a group along with the comments directly above
+// them, and removes the group from children sections. Includes in the sections are kept.
+func (doc *Document) RemoveGroup(name string) error {
+	if doc.findHeader(name) < 0 {
+		return fmt.Errorf("Group %s isn't declared in %s", name, doc.name())
+	}
+
+	var remove []int
+	for i := 0; i < len(doc.lines); i++ {
+		line := doc.lines[i]
+		if line.kind == docChild && line.name == name {
+			remove = append(remove, i)
+			continue
+		}
+		if line.kind != docHeader || line.name != name {
+			continue
+		}
+
+		end := i + 1
+		for end < len(doc.lines) && doc.lines[end].kind != docHeader {
+			end++
+		}
+		// Comments directly above the next header belong to it
+		if end < len(doc.lines) {
+			end = doc.commentsAbove(end)
+			if end <= i {
+				end = i + 1
+			}
+		}
+		for j := doc.commentsAbove(i); j < end; j++ {
+			if doc.lines[j].kind != docInclude {
+				remove = append(remove, j)
+			}
+		}
+		i = end - 1
+	}
+
+	// Devices that stay in other groups keep their settings
+	removed := make(map[int]bool, len(remove))
+	for _, i := range remove {
+		removed[i] = true
+	}
+	for _, i := range remove {
+		if doc.lines[i].kind != docDevice {
+			continue
+		}
+		var declarations []int
+		for j, line := range doc.lines {
+			if line.kind == docDevice && line.name == doc.lines[i].name && removed[j] {
+				declarations = append(declarations, j)
+			}
+		}
+		doc.moveDeviceSettings(doc.lines[i].name, declarations)
+	}
+
+	doc.removeLines(remove)
+	return nil
+}
+
+// SetDeviceSetting sets a setting on the first declaration of a device
+func (doc *Document) SetDeviceSetting(name, key, value string) error {
+	i, err := doc.deviceDeclaration(name)
+	if err != nil {
+		return err
+	}
+	setting, err := formatSetting(key, value)
+	if err != nil {
+		return err
+	}
+	doc.lines[i].text = setLineSetting(doc.lines[i].text, len(leadingSpace(doc.lines[i].text))+len(name), key, setting)
+	return nil
+}
+
+// DeleteDeviceSetting removes a setting from the first declaration of a device
+func (doc *Document) DeleteDeviceSetting(name, key string) error {
+	i, err := doc.deviceDeclaration(name)
+	if err != nil {
+		return err
+	}
+	doc.lines[i].text = deleteLineSetting(doc.lines[i].text, len(leadingSpace(doc.lines[i].text))+len(name), key)
+	return nil
+}
+
+// SetGroupSetting sets a setting on the first header of a group. Global settings are set
+// on their own line in the global group which is added if needed.
+func (doc *Document) SetGroupSetting(group, key, value string) error {
+	if group == "global" {
+		return doc.setGlobal(key, value)
+	}
+	i := doc.findHeader(group)
+	if i < 0 {
+		return fmt.Errorf("Group %s isn't declared in %s", group, doc.name())
+	}
+	setting, err := formatSetting(key, value)
+	if err != nil {
+		return err
+	}
+	doc.lines[i].text = setLineSetting(doc.lines[i].text, headerLength(doc.lines[i].text), key, setting)
+	return nil
+}
+
+// DeleteGroupSetting removes a setting from the first header of a group, or from the global group
+func (doc *Document) DeleteGroupSetting(group, key string) error {
+	if group == "global" {
+		var remove []int
+		for i, line := range doc.lines {
+			if line.kind != docGlobal {
+				continue
+			}
+			doc.lines[i].text = deleteLineSetting(line.text, 0, key)
+			if strings.TrimSpace(doc.lines[i].text) == "" {
+				remove = append(remove, i)
+			}
+		}
+		doc.removeLines(remove)
+		return nil
+	}
+
+	i := doc.findHeader(group)
+	if i < 0 {
+		return fmt.Errorf("Group %s isn't declared in %s", group, doc.name())
+	}
+	doc.lines[i].text = deleteLineSetting(doc.lines[i].text, headerLength(doc.lines[i].text), key)
+	return nil
+}
+
+// setGlobal replaces a global setting where it's given or adds it to the end of the global group
+func (doc *Document) setGlobal(key, value string) error {
+	formatted, err := formatValue(key, value)
+	if err != nil {
+		return err
+	}
+	setting := key + " = " + formatted
+
+	for i, line := range doc.lines {
+		if line.kind != docGlobal {
+			continue
+		}
+		if settings, _ := lineSettings([]byte(line.text)); hasKey(settings, key) {
+			doc.lines[i].text = setLineSetting(line.text, 0, key, setting)
+			return nil
+		}
+	}
+
+	header := doc.findHeader("global")
+	if header >= 0 {
+		doc.insert(doc.lastContent(header)+1, docLine{text: setting, kind: docGlobal, section: "global"})
+		return nil
+	}
+
+	// The global group is added before the first group
+	at := len(doc.lines)
+	for i, line := range doc.lines {
+		if line.kind == docHeader {
+			at = doc.commentsAbove(i)
+			break
+		}
+	}
+	lines := []docLine{
+		{text: "[global]", kind: docHeader, section: "global", name: "global"},
+		{text: setting, kind: docGlobal, section: "global"},
+	}
+	if at < len(doc.lines) {
+		lines = append(lines, docLine{kind: docOther})
+	}
+	doc.insert(at, lines...)
+	return nil
+}
+
+// deviceDeclaration returns the index of the line declaring a device and its settings
+func (doc *Document) deviceDeclaration(name string) (int, error) {
+	i := doc.findDevice(name)
+	if i < 0 {
+		return -1, fmt.Errorf("Device %s isn't declared in %s", name, doc.name())
+	}
+	if doc.lines[i].name != name {
+		return -1, doc.rangeError(name, i)
+	}
+	return i, nil
+}
+
+// findDevice returns the index of the first line declaring a device, either by name or
+// in a range. -1 is returned if the device isn't declared.
+func (doc *Document) findDevice(name string) int {
+	for i, line := range doc.lines {
+		if line.kind != docDevice {
+			continue
+		}
+		if line.name == name {
+			return i
+		}
+		if rangeRegex.MatchString(line.name) {
+			names, _ := expandRanges(line.name)
+			if containsString(names, name) {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// findHeader returns the index of the first header of a group or -1
+func (doc *Document) findHeader(group string) int {
+	for i, line := range doc.lines {
+		if line.kind == docHeader && line.name == group {
+			return i
+		}
+	}
+	return -1
+}
+
+func (doc *Document) rangeError(name string, i int) error {
+	return fmt.Errorf("Device %s is declared in the range %s at %s and can't be changed by itself",
+		name, doc.lines[i].name, lineOrigin{file: doc.filename, line: i + 1})
+}
+
+// moveDeviceSettings moves the settings of a device to its next declaration if its first
+// declaration is one of the lines being removed
+func (doc *Document) moveDeviceSettings(name string, remove []int) {
+	first := doc.findDevice(name)
+	if first < 0 || !containsInt(remove, first) {
+		return
+	}
+	for i := first + 1; i < len(doc.lines); i++ {
+		line := doc.lines[i]
+		if line.kind != docDevice || line.name != name || containsInt(remove, i) {
+			continue
+		}
+		firstText := doc.lines[first].text
+		rest := strings.TrimSpace(firstText[len(leadingSpace(firstText))+len(name):])
+		if rest != "" {
+			doc.lines[i].text = strings.TrimRight(line.text, " \t") + " " + rest
+		}
+		return
+	}
+}
+
+// lastContent returns the index of the last line of the section starting at header that
+// isn't blank, a comment, or an include
+func (doc *Document) lastContent(header int) int {
+	last := header
+	for i := header + 1; i < len(doc.lines) && doc.lines[i].kind != docHeader; i++ {
+		if kind := doc.lines[i].kind; kind != docOther && kind != docInclude {
+			last = i
+		}
+	}
+	return last
+}
+
+// commentsAbove returns the index of the first of the comment lines directly above line i
+func (doc *Document) commentsAbove(i int) int {
+	for i > 0 && strings.HasPrefix(strings.TrimSpace(doc.lines[i-1].text), "#") {
+		i--
+	}
+	return i
+}
+
+func (doc *Document) insert(at int, lines ...docLine) {
+	doc.lines = append(doc.lines[:at], append(lines, doc.lines[at:]...)...)
+}
+
+// removeLines removes the lines at the given indexes. A blank line left next to another
+// blank line is also removed.
+func (doc *Document) removeLines(indexes []int) {
+	if len(indexes) == 0 {
+		return
+	}
+	remove := make(map[int]bool, len(indexes))
+	for _, i := range indexes {
+		remove[i] = true
+	}
+
+	lines := make([]docLine, 0, len(doc.lines))
+	for i, line := range doc.lines {
+		if remove[i] {
+			continue
+		}
+		blank := strings.TrimSpace(line.text) == ""
+		if blank && remove[i-1] && (len(lines) == 0 || strings.TrimSpace(lines[len(lines)-1].text) == "") {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	for len(lines) > 0 && strings.TrimSpace(lines[len(lines)-1].text) == "" {
+		lines = lines[:len(lines)-1]
+	}
+	doc.lines = lines
+}
+
+func (doc *Document) name() string {
+	if doc.filename == "" {
+		return "the inventory"
+	}
+	return doc.filename
+}
+
+// setLineSetting replaces a setting in the part of line after start, or appends it to the line
+func setLineSetting(line string, start int, key, setting string) string {
+	prefix, rest := line[:start], line[start:]
+	for _, m := range lineSettingRegex.FindAllStringSubmatchIndex(rest, -1) {
+		if rest[m[2]:m[3]] != key {
+			continue
+		}
+		after := removeLineSettings(rest[m[1]:], []string{key})
+		if after != "" {
+			after = " " + after
+		}
+		return prefix + rest[:m[0]] + setting + after
+	}
+	rest = strings.TrimRight(rest, " \t")
+	if rest == "" && strings.TrimSpace(prefix) == "" {
+		return prefix + setting
+	}
+	return prefix + rest + " " + setting
+}
+
+// deleteLineSetting removes a setting from the part of line after start
+func deleteLineSetting(line string, start int, key string) string {
+	rest := removeLineSettings(line[start:], []string{key})
+	if rest == strings.TrimSpace(line[start:]) {
+		return line
+	}
+	if rest == "" || start == 0 {
+		return line[:start] + rest
+	}
+	return line[:start] + " " + rest
+}
+
+// headerLength returns the length of the group header at the start of line
+func headerLength(line string) int {
+	trimmed := strings.TrimLeft(line, " \t")
+	return len(line) - len(trimmed) + len(groupNameRegex.FindString(trimmed))
+}
+
+func isChildrenHeader(line string) bool {
+	groupLine := groupNameRegex.FindStringSubmatch(strings.TrimSpace(line))
+	return len(groupLine) > 0 && groupLine[2] != ""
+}
+
+func leadingSpace(line string) string {
+	return line[:len(line)-len(strings.TrimLeft(line, " \t"))]
+}
+
+func hasKey(settings map[string]string, key string) bool {
+	_, ok := settings[key]
+	return ok
+}
+
+func sortedKeys(settings map[string]string) []string {
+	keys := make([]string, 0, len(settings))
+	for key := range settings {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func containsInt(list []int, n int) bool {
+	for _, item := range list {
+		if item == n {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,217 @@
+package devices
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var testDocument = `# Inventory for the main campus
+[global]
+remote_user = peter
+# Shared password
+remote_password = cottentail
+
+# Boston
+[boston co-location] site=boston
+server1
+  server2 address=10.0.0.2 protocol=telnet
+server[3:4]
+
+@included.conf
+
+# San Francisco
+[san fran location]
+server1b cisco_enable=orange_cone
+server2b
+
+[web app]
+server1
+server2b
+
+[all:children]
+boston co-location
+san fran location
+`
+
+func TestDocumentRoundTrip(t *testing.T) {
+	doc, err := ParseDocument(strings.NewReader(testDocument), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if doc.String() != testDocument {
+		t.Errorf("document changed without edits:\n%s", doc.String())
+	}
+}
+
+func TestDocumentEdits(t *testing.T) {
+	doc, err := ParseDocument(strings.NewReader(testDocument), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	edits := []error{
+		doc.SetGroupSetting("global", "remote_user", "jarvis"),
+		doc.SetGroupSetting("global", "protocol", "ssh"),
+		doc.DeleteGroupSetting("global", "remote_password"),
+		doc.SetGroupSetting("boston co-location", "site", "boston 2"),
+		doc.SetGroupSetting("web app", "priority", "10"),
+		doc.SetDeviceSetting("server2", "protocol", "ssh"),
+		doc.SetDeviceSetting("server2b", "address", "10.0.1.2"),
+		doc.DeleteDeviceSetting("server1b", "cisco_enable"),
+		doc.AddDevice("boston co-location", "server5", map[string]string{"address": "10.0.0.5"}),
+		doc.AddDevice("web app", "server5", nil),
+		doc.AddDevice("new york", "server1ny", nil),
+		doc.RemoveDevice("server1", "web app"),
+		doc.RemoveGroup("san fran location"),
+	}
+	for i, err := range edits {
+		if err != nil {
+			t.Errorf("edit %d returned error: %s", i, err)
+		}
+	}
+
+	expected := `# Inventory for the main campus
+[global]
+remote_user = jarvis
+# Shared password
+protocol = ssh
+
+# Boston
+[boston co-location] site="boston 2"
+server1
+  server2 address=10.0.0.2 protocol=ssh
+server[3:4]
+server5 address=10.0.0.5
+
+@included.conf
+
+[web app] priority=10
+server2b address=10.0.1.2
+server5
+
+[all:children]
+boston co-location
+
+[new york]
+server1ny
+`
+	if doc.String() != expected {
+		t.Errorf("incorrect document. Expected:\n%s\nGot:\n%s", expected, doc.String())
+	}
+
+	errorEdits := map[string]error{
+		"device in range":       doc.SetDeviceSetting("server3", "address", "10.0.0.3"),
+		"missing device":        doc.SetDeviceSetting("server9", "address", "10.0.0.9"),
+		"missing group":         doc.SetGroupSetting("atlanta", "site", "atl"),
+		"duplicate group":       doc.AddGroup("web app", nil),
+		"group named as device": doc.AddGroup("server5", nil),
+		"duplicate device":      doc.AddDevice("web app", "server5", nil),
+		"settings redeclared":   doc.AddDevice("all", "server5", map[string]string{"a": "b"}),
+		"device in global":      doc.AddDevice("global", "server6", nil),
+		"invalid device name":   doc.AddDevice("web app", "server 6", nil),
+		"invalid setting":       doc.SetDeviceSetting("server5", "a b", "c"),
+		"remove range device":   doc.RemoveDevice("server4", ""),
+	}
+	for name, err := range errorEdits {
+		if err == nil {
+			t.Errorf("%s should return an error", name)
+		}
+	}
+}
+
+func TestDocumentMovesSettings(t *testing.T) {
+	doc, err := ParseDocument(strings.NewReader(strings.Replace(testDocument, "@included.conf\n", "", 1)), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// server1b is only in san fran location so it's removed with its last declaration
+	if err := doc.RemoveDevice("server1b", "san fran location"); err != nil {
+		t.Fatal(err)
+	}
+	if err := doc.RemoveGroup("san fran location"); err != nil {
+		t.Fatal(err)
+	}
+	if err := doc.RemoveDevice("server2", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	list, err := doc.DeviceList()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, exists := list.Devices["server1b"]; exists {
+		t.Error("server1b should be removed with its only group")
+	}
+	if _, exists := list.Devices["server2"]; exists {
+		t.Error("server2 should be removed")
+	}
+	if _, exists := list.Groups["san fran location"]; exists {
+		t.Error("removed group should not exist")
+	}
+	if children := list.Groups["all"].Children; len(children) != 1 || children[0] != "boston co-location" {
+		t.Errorf("incorrect children of all: %v", children)
+	}
+	if !strings.Contains(doc.String(), "[web app]\nserver1\nserver2b\n") {
+		t.Errorf("incorrect web app section:\n%s", doc.String())
+	}
+
+	// Settings on the first declaration move to the next one when it's removed
+	doc, _ = ParseDocument(strings.NewReader("[a]\nsw1 address=10.0.0.1\n\n[b]\nsw1\n"), "")
+	doc.RemoveGroup("a")
+	if doc.String() != "[b]\nsw1 address=10.0.0.1\n" {
+		t.Errorf("settings of sw1 should move to group b:\n%s", doc.String())
+	}
+
+	doc, _ = ParseDocument(strings.NewReader("[a]\nsw1 address=10.0.0.1\n\n[b]\nsw2\nsw1\n"), "")
+	doc.RemoveDevice("sw1", "a")
+	if doc.String() != "[a]\n\n[b]\nsw2\nsw1 address=10.0.0.1\n" {
+		t.Errorf("settings of sw1 should move to group b:\n%s", doc.String())
+	}
+}
+
+func TestDocumentBuild(t *testing.T) {
+	dir, err := ioutil.TempDir("", "inca-document")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	doc := NewDocument()
+	doc.AddDevice("core", "router1", map[string]string{"address": "10.0.0.1", "protocol": "ssh"})
+	doc.AddDevice("core", "router2", nil)
+	doc.SetGroupSetting("global", "remote_user", "netops")
+	doc.SetGroupSetting("core", "protected", "true")
+
+	expected := "[global]\nremote_user = netops\n\n[core] protected=true\nrouter1 address=10.0.0.1 protocol=ssh\nrouter2\n"
+	if doc.String() != expected {
+		t.Errorf("incorrect document. Expected:\n%s\nGot:\n%s", expected, doc.String())
+	}
+
+	filename := filepath.Join(dir, "hosts")
+	if err := doc.SaveAs(filename); err != nil {
+		t.Fatal(err)
+	}
+	list, err := ParseFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !list.Devices["router2"].IsProtected() || list.Devices["router2"].GetSetting("remote_user") != "netops" {
+		t.Error("incorrect settings after saving the document")
+	}
+
+	// Documents for devices and groups open the file declaring them
+	deviceDoc, err := list.Devices["router1"].Document()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deviceDoc.Filename() != filename {
+		t.Errorf("incorrect document file. Expected %s, got %s", filename, deviceDoc.Filename())
+	}
+	if _, err := list.Groups["core"].Document(); err != nil {
+		t.Fatal(err)
+	}
+}
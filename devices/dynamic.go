@@ -0,0 +1,112 @@
+package devices
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// groupMatch is the rules of a dynamic group. A device joins the group if it matches every rule given.
+type groupMatch struct {
+	name         *regexp.Regexp
+	setting      string
+	settingValue string
+}
+
+// readGroupMatch removes the match_name and match_setting settings from a group and
+// returns the rules they give. Nil is returned if the group has no rules.
+func readGroupMatch(g *Group) (*groupMatch, error) {
+	name, hasName := g.settings["match_name"]
+	setting, hasSetting := g.settings["match_setting"]
+	if !hasName && !hasSetting {
+		return nil, nil
+	}
+	delete(g.settings, "match_name")
+	delete(g.settings, "match_setting")
+
+	if g.Name == "global" {
+		return nil, fmt.Errorf("Global group cannot have match rules\n")
+	}
+
+	match := &groupMatch{}
+	if hasName {
+		re, err := regexp.Compile(name)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid match_name of group %s: %s\n", g.Name, err.Error())
+		}
+		match.name = re
+	}
+	if hasSetting {
+		parts := strings.SplitN(setting, ":", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("match_setting of group %s must be in the form setting:value\n", g.Name)
+		}
+		match.setting = parts[0]
+		match.settingValue = strings.Trim(parts[1], `"`)
+		if _, err := path.Match(match.settingValue, ""); err != nil {
+			return nil, fmt.Errorf("Invalid match_setting pattern of group %s\n", g.Name)
+		}
+	}
+	return match, nil
+}
+
+// matches returns if d matches every rule. The value of the setting may be a glob. The
+// tags setting matches if any of the device's tags match.
+func (m *groupMatch) matches(d *Device) bool {
+	if m.name != nil && !m.name.MatchString(d.Name) {
+		return false
+	}
+	if m.setting == "" {
+		return true
+	}
+
+	values := []string{d.getSetting(m.setting)}
+	if m.setting == "tags" {
+		values = d.Tags()
+	}
+	for _, value := range values {
+		if ok, _ := path.Match(m.settingValue, value); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// addMatchedDevices adds devices to the dynamic groups whose rules they match. Every device is
+// checked before any are added so membership of one dynamic group never depends on another.
+func addMatchedDevices(devices *DeviceList, matches map[string]*groupMatch) {
+	groupNames := make([]string, 0, len(matches))
+	for name := range matches {
+		groupNames = append(groupNames, name)
+	}
+	sort.Strings(groupNames)
+
+	deviceNames := make([]string, 0, len(devices.Devices))
+	for name := range devices.Devices {
+		deviceNames = append(deviceNames, name)
+	}
+	sort.Strings(deviceNames)
+
+	type membership struct {
+		device *Device
+		group  *Group
+	}
+	var found []membership
+	for _, groupName := range groupNames {
+		group := devices.Groups[groupName]
+		for _, deviceName := range deviceNames {
+			device := devices.Devices[deviceName]
+			if containsString(device.Groups, groupName) || !matches[groupName].matches(device) {
+				continue
+			}
+			found = append(found, membership{device, group})
+		}
+	}
+
+	for _, m := range found {
+		m.device.Groups = append(m.device.Groups, m.group.Name)
+		m.group.Devices = append(m.group.Devices, m.device)
+	}
+}
@@ -0,0 +1,118 @@
+package devices
+
+import (
+	"fmt"
+	"strings"
+)
+
+// UpdateDeviceSettings changes the settings on the line declaring a device in the inventory file
+// it was read from. Settings in set are added or replaced and settings in remove are removed. Only
+// devices declared by name in a standard format inventory file can be updated. The rest of the
+// file is left as it is.
+func UpdateDeviceSettings(d *Device, set map[string]string, remove []string) error {
+	doc, err := d.Document()
+	if err != nil {
+		return err
+	}
+	for _, key := range sortedKeys(set) {
+		if err := doc.SetDeviceSetting(d.Name, key, set[key]); err != nil {
+			return err
+		}
+	}
+	for _, key := range remove {
+		if err := doc.DeleteDeviceSetting(d.Name, key); err != nil {
+			return err
+		}
+	}
+	return doc.Save()
+}
+
+// Document opens the inventory file declaring the device. Devices given by a script,
+// a plugin, or in a YAML or JSON inventory don't have a document.
+func (d *Device) Document() (*Document, error) {
+	if d.origin.file == "" {
+		return nil, fmt.Errorf("Device %s wasn't read from a standard format inventory file", d.Name)
+	}
+	if d.origin.script {
+		return nil, fmt.Errorf("Device %s is given by the script %s and must be changed in its source", d.Name, d.origin.file)
+	}
+	doc, err := OpenDocument(d.origin.file)
+	if err != nil {
+		return nil, err
+	}
+
+	// The document must still declare the device where it was read
+	i := d.origin.line - 1
+	if i >= len(doc.lines) || doc.lines[i].kind != docDevice {
+		return nil, fmt.Errorf("Inventory file %s has changed since it was read", d.origin.file)
+	}
+	if doc.lines[i].name != d.Name {
+		if rangeRegex.MatchString(doc.lines[i].name) {
+			return nil, doc.rangeError(d.Name, i)
+		}
+		return nil, fmt.Errorf("Inventory file %s has changed since it was read", d.origin.file)
+	}
+	return doc, nil
+}
+
+// Document opens the inventory file declaring the group. Groups in a YAML or JSON
+// inventory or given by a script or plugin don't have a document.
+func (g *Group) Document() (*Document, error) {
+	if g.origin.file == "" {
+		return nil, fmt.Errorf("Group %s wasn't read from a standard format inventory file", g.Name)
+	}
+	if g.origin.script {
+		return nil, fmt.Errorf("Group %s is given by the script %s and must be changed in its source", g.Name, g.origin.file)
+	}
+	doc, err := OpenDocument(g.origin.file)
+	if err != nil {
+		return nil, err
+	}
+	if doc.findHeader(g.Name) < 0 {
+		return nil, fmt.Errorf("Inventory file %s has changed since it was read", g.origin.file)
+	}
+	return doc, nil
+}
+
+// removeLineSettings removes the settings named in keys from a line of settings
+func removeLineSettings(line string, keys []string) string {
+	matches := lineSettingRegex.FindAllStringSubmatchIndex(line, -1)
+	for i := len(matches) - 1; i >= 0; i-- {
+		m := matches[i]
+		if !containsString(keys, line[m[2]:m[3]]) {
+			continue
+		}
+		start := m[0]
+		for start > 0 && (line[start-1] == ' ' || line[start-1] == '\t') {
+			start--
+		}
+		line = line[:start] + line[m[1]:]
+	}
+	return strings.TrimSpace(line)
+}
+
+// formatSetting returns key=value quoting the value if needed
+func formatSetting(key, value string) (string, error) {
+	value, err := formatValue(key, value)
+	if err != nil {
+		return "", err
+	}
+	return key + "=" + value, nil
+}
+
+// formatValue returns the value of the setting key quoted if needed
+func formatValue(key, value string) (string, error) {
+	if !lineSettingKeyRegex.MatchString(key) {
+		return "", fmt.Errorf("Invalid setting name \"%s\"", key)
+	}
+	if strings.ContainsAny(value, "\n\r") {
+		return "", fmt.Errorf("Value of setting %s can't contain a new line", key)
+	}
+	if value != "" && !strings.ContainsAny(value, " \t\"") {
+		return value, nil
+	}
+	if strings.ContainsAny(value, "\"\\") {
+		return "", fmt.Errorf("Value of setting %s can't contain both spaces and quotes or backslashes", key)
+	}
+	return "\"" + value + "\"", nil
+}
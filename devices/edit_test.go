@@ -0,0 +1,61 @@
+package devices
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestUpdateDeviceSettings(t *testing.T) {
+	dir, err := ioutil.TempDir("", "inca-edit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	hosts := filepath.Join(dir, "hosts")
+	included := filepath.Join(dir, "included.conf")
+	ioutil.WriteFile(hosts, []byte("# Core routers\n[core]\n  router1 address=10.0.0.1 disabled=true\nrouter[2:3]\n\n@included.conf\n"), 0644)
+	ioutil.WriteFile(included, []byte("[access]\n\n# Closet 1\nsw1 address=10.0.1.1 remote_user=\"net ops\"\n"), 0644)
+
+	list, err := ParseFile(hosts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := UpdateDeviceSettings(list.Devices["sw1"], map[string]string{"disabled": "true", "disabled_reason": "Vendor case"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := UpdateDeviceSettings(list.Devices["router1"], nil, []string{"disabled"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := UpdateDeviceSettings(list.Devices["router2"], map[string]string{"disabled": "true"}, nil); err == nil {
+		t.Error("device in a range should return an error")
+	}
+	if err := UpdateDeviceSettings(list.Devices["sw1"], map[string]string{"note": "a \"b\""}, nil); err == nil {
+		t.Error("value with spaces and quotes should return an error")
+	}
+
+	data, _ := ioutil.ReadFile(hosts)
+	if string(data) != "# Core routers\n[core]\n  router1 address=10.0.0.1\nrouter[2:3]\n\n@included.conf\n" {
+		t.Errorf("incorrect inventory file:\n%s", data)
+	}
+	data, _ = ioutil.ReadFile(included)
+	if string(data) != "[access]\n\n# Closet 1\nsw1 address=10.0.1.1 remote_user=\"net ops\" disabled=true disabled_reason=\"Vendor case\"\n" {
+		t.Errorf("incorrect included file:\n%s", data)
+	}
+
+	list, err = ParseFile(hosts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !list.Devices["sw1"].IsDisabled() || list.Devices["router1"].IsDisabled() {
+		t.Error("incorrect disabled devices after update")
+	}
+
+	list, _ = ParseString("[core]\nrouter1\n")
+	if err := UpdateDeviceSettings(list.Devices["router1"], map[string]string{"disabled": "true"}, nil); err == nil {
+		t.Error("device not read from a file should return an error")
+	}
+}
@@ -0,0 +1,366 @@
+package devices
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// defaultHTTPFields maps settings to the fields of a NetBox device
+var defaultHTTPFields = map[string]string{
+	"address":  "primary_ip.address",
+	"platform": "platform.slug",
+	"site":     "site.slug",
+	"tags":     "tags.slug",
+}
+
+// globalSources are loaded into every inventory read with ParseFile
+var globalSources []inventorySource
+
+// inventorySource gives devices that are merged into an inventory after it's parsed
+type inventorySource interface {
+	load() (*DeviceList, error)
+	String() string
+}
+
+// httpSource reads devices from an HTTP JSON API such as the NetBox /api/dcim/devices/
+// endpoint. Each page is an object with a "results" list of devices and a "next" URL.
+type httpSource struct {
+	url      string
+	token    string
+	group    string
+	groupBy  []string
+	fields   map[string]string
+	cacheTTL time.Duration
+	timeout  time.Duration
+}
+
+// AddHTTPSource adds an HTTP inventory source to every inventory read with ParseFile.
+// The line is the same as the arguments of an "@http" line in an inventory file.
+func AddHTTPSource(line string) error {
+	source, err := parseHTTPLine(line)
+	if err != nil {
+		return err
+	}
+	globalSources = append(globalSources, source)
+	return nil
+}
+
+// parseHTTPLine parses the arguments of a "@http [options] url" line. Options are key=value
+// pairs given before the URL. The options are token, group, group_by, map, cache_ttl, and timeout.
+// Group_by and map may be given multiple times.
+func parseHTTPLine(line string) (*httpSource, error) {
+	fields, err := splitArgs(line)
+	if err != nil {
+		return nil, err
+	}
+
+	source := &httpSource{
+		group:   "dcim",
+		fields:  make(map[string]string),
+		timeout: 30 * time.Second,
+	}
+	for setting, field := range defaultHTTPFields {
+		source.fields[setting] = field
+	}
+
+	for len(fields) > 0 {
+		parts := strings.SplitN(fields[0], "=", 2)
+		if len(parts) != 2 {
+			break
+		}
+		switch parts[0] {
+		case "token":
+			source.token = parts[1]
+		case "group":
+			source.group = parts[1]
+		case "group_by":
+			source.groupBy = append(source.groupBy, parts[1])
+		case "map":
+			mapping := strings.SplitN(parts[1], ":", 2)
+			if len(mapping) != 2 || !lineSettingKeyRegex.MatchString(mapping[0]) {
+				return nil, fmt.Errorf("HTTP source map option must be in the form map=setting:field")
+			}
+			if mapping[1] == "" {
+				delete(source.fields, mapping[0])
+			} else {
+				source.fields[mapping[0]] = mapping[1]
+			}
+		case "cache_ttl":
+			source.cacheTTL, err = parseTTL(parts[1])
+			if err != nil {
+				return nil, err
+			}
+		case "timeout":
+			source.timeout, err = parseTTL(parts[1])
+			if err != nil {
+				return nil, fmt.Errorf("Invalid timeout %s", parts[1])
+			}
+		default:
+			return nil, fmt.Errorf("Unknown HTTP source option %s", parts[0])
+		}
+		fields = fields[1:]
+	}
+
+	if len(fields) != 1 {
+		return nil, fmt.Errorf("HTTP source must be given one URL")
+	}
+	if u, err := url.Parse(fields[0]); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, fmt.Errorf("Invalid HTTP source URL %s", fields[0])
+	}
+	if source.group == "" {
+		return nil, fmt.Errorf("HTTP source group can't be empty")
+	}
+	source.url = fields[0]
+	return source, nil
+}
+
+func (s *httpSource) String() string {
+	return "HTTP source " + s.url
+}
+
+// load fetches the devices, or uses the cached results, and builds an inventory from them.
+// Child groups are not linked.
+func (s *httpSource) load() (*DeviceList, error) {
+	cacheFile := ""
+	if s.cacheTTL > 0 {
+		cacheFile = s.cacheFile()
+		if cacheFile != "" {
+			if info, err := os.Stat(cacheFile); err == nil && time.Since(info.ModTime()) < s.cacheTTL {
+				if data, err := ioutil.ReadFile(cacheFile); err == nil {
+					var results []interface{}
+					if err := json.Unmarshal(data, &results); err == nil {
+						return s.build(results)
+					}
+				}
+			}
+		}
+	}
+
+	results, err := s.fetch()
+	if err != nil {
+		return nil, err
+	}
+	devices, err := s.build(results)
+	if err != nil {
+		return nil, err
+	}
+
+	// The results may contain sensitive details so only the user may read them
+	if cacheFile != "" {
+		if data, err := json.Marshal(results); err == nil {
+			if err := os.MkdirAll(filepath.Dir(cacheFile), 0700); err == nil {
+				ioutil.WriteFile(cacheFile, data, 0600)
+			}
+		}
+	}
+	return devices, nil
+}
+
+// fetch reads every page of results following the next link of each page. Next links must
+// have the same scheme and host as the source's URL.
+func (s *httpSource) fetch() ([]interface{}, error) {
+	token, err := resolveSetting("token", s.token)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", s, err.Error())
+	}
+
+	client := &http.Client{Timeout: s.timeout}
+	var results []interface{}
+	seen := make(map[string]bool)
+	next := s.url
+
+	for next != "" {
+		if seen[next] {
+			return nil, fmt.Errorf("%s: Page %s was given twice", s, next)
+		}
+		seen[next] = true
+
+		page, err := s.fetchPage(client, next, token)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, page.Results...)
+
+		next = ""
+		if page.Next != "" {
+			base, _ := url.Parse(s.url)
+			ref, err := url.Parse(page.Next)
+			if err != nil {
+				return nil, fmt.Errorf("%s: Invalid next page %s", s, page.Next)
+			}
+			nextURL := base.ResolveReference(ref)
+			// The token is sent with every page so it must never leave the configured server
+			if nextURL.Scheme != base.Scheme || !strings.EqualFold(nextURL.Host, base.Host) {
+				return nil, fmt.Errorf("%s: Next page is on %s://%s, not the source's server", s, nextURL.Scheme, nextURL.Host)
+			}
+			next = nextURL.String()
+		}
+	}
+	return results, nil
+}
+
+type httpPage struct {
+	Next    string        `json:"next"`
+	Results []interface{} `json:"results"`
+}
+
+func (s *httpSource) fetchPage(client *http.Client, pageURL, token string) (*httpPage, error) {
+	req, err := http.NewRequest("GET", pageURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", s, err.Error())
+	}
+	req.Header.Set("Accept", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Token "+token)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%s failed: %s", s, err.Error())
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s failed: %s", s, resp.Status)
+	}
+
+	var page httpPage
+	decoder := json.NewDecoder(resp.Body)
+	decoder.UseNumber()
+	if err := decoder.Decode(&page); err != nil {
+		return nil, fmt.Errorf("%s gave invalid JSON: %s", s, err.Error())
+	}
+	if page.Results == nil {
+		return nil, fmt.Errorf("%s gave a page without results", s)
+	}
+	return &page, nil
+}
+
+// build creates an inventory from the results. Every device is in the source's group and
+// the groups named by its group_by fields. Devices without a name are skipped. Settings
+// are literal so they're never resolved from an external source.
+func (s *httpSource) build(results []interface{}) (*DeviceList, error) {
+	devices := &DeviceList{
+		Groups:  make(map[string]*Group),
+		Devices: make(map[string]*Device),
+	}
+	addToGroup := func(device *Device, name string) {
+		group, exists := devices.Groups[name]
+		if !exists {
+			group = &Group{Name: name, settings: make(map[string]string), list: devices}
+			devices.Groups[name] = group
+		}
+		if !containsString(device.Groups, name) {
+			device.Groups = append(device.Groups, name)
+			group.Devices = append(group.Devices, device)
+		}
+	}
+
+	settingNames := make([]string, 0, len(s.fields))
+	for setting := range s.fields {
+		settingNames = append(settingNames, setting)
+	}
+	sort.Strings(settingNames)
+
+	for _, result := range results {
+		name, _ := fieldValue(result, "name")
+		if name == "" {
+			continue
+		}
+		if _, exists := devices.Devices[name]; exists {
+			return nil, fmt.Errorf("%s gave device %s twice", s, name)
+		}
+
+		device := &Device{
+			Name:     name,
+			settings: make(map[string]string),
+			list:     devices,
+		}
+		for _, setting := range settingNames {
+			value, ok := fieldValue(result, s.fields[setting])
+			if !ok {
+				continue
+			}
+			// Addresses are given with their prefix length, such as 10.0.0.1/24
+			if setting == "address" {
+				if i := strings.IndexByte(value, '/'); i >= 0 {
+					value = value[:i]
+				}
+			}
+			device.settings[setting] = value
+		}
+		devices.Devices[name] = device
+
+		addToGroup(device, s.group)
+		for _, field := range s.groupBy {
+			if value, ok := fieldValue(result, field); ok && value != "" {
+				addToGroup(device, value)
+			}
+		}
+	}
+	// Whoever controls the API controls these values, they must never run commands or read secrets
+	devices.markLiteral()
+	return devices, nil
+}
+
+// fieldValue returns the value of a field given as a path of keys separated by periods
+// such as "site.slug". If a key gives a list, the rest of the path is read from each item
+// and the values are joined with commas. False is returned if the field is missing or null.
+func fieldValue(value interface{}, path string) (string, bool) {
+	key, rest := path, ""
+	if i := strings.IndexByte(path, '.'); i >= 0 {
+		key, rest = path[:i], path[i+1:]
+	}
+
+	obj, ok := value.(map[string]interface{})
+	if !ok {
+		return "", false
+	}
+	value = obj[key]
+
+	if list, ok := value.([]interface{}); ok {
+		var values []string
+		for _, item := range list {
+			if rest == "" {
+				if v, ok := scalarString(item); ok && item != nil {
+					values = append(values, v)
+				}
+			} else if v, ok := fieldValue(item, rest); ok {
+				values = append(values, v)
+			}
+		}
+		return strings.Join(values, ","), true
+	}
+	if value == nil {
+		return "", false
+	}
+	if rest != "" {
+		return fieldValue(value, rest)
+	}
+	return scalarString(value)
+}
+
+// cacheFile returns the file used to cache the source's results. The token is included
+// in the hash since it may change what the API returns.
+func (s *httpSource) cacheFile() string {
+	dir := inventoryCacheDir()
+	if dir == "" {
+		return ""
+	}
+	hash := sha256.New()
+	for _, part := range []string{s.url, s.token} {
+		hash.Write([]byte(part))
+		hash.Write([]byte{0})
+	}
+	return filepath.Join(dir, "http-"+hex.EncodeToString(hash.Sum(nil))+".json")
+}
@@ -0,0 +1,248 @@
+package devices
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var testHTTPPages = []string{
+	`{
+	"count": 3,
+	"next": "%s/api/dcim/devices/?limit=2&offset=2",
+	"previous": null,
+	"results": [
+		{
+			"id": 1,
+			"name": "core1",
+			"primary_ip": {"id": 10, "address": "10.0.0.1/24"},
+			"platform": {"id": 1, "name": "Junos", "slug": "junos"},
+			"site": {"id": 1, "name": "HQ", "slug": "hq"},
+			"role": {"id": 1, "name": "Core", "slug": "core"},
+			"tags": [{"id": 1, "name": "PCI", "slug": "pci"}, {"id": 2, "name": "Edge", "slug": "edge"}],
+			"serial": "AB123"
+		},
+		{
+			"id": 2,
+			"name": "access1",
+			"primary_ip": null,
+			"platform": {"id": 2, "name": "Cisco IOS", "slug": "ios"},
+			"site": {"id": 2, "name": "Branch", "slug": "branch"},
+			"role": {"id": 2, "name": "Access", "slug": "access"},
+			"tags": [],
+			"serial": ""
+		}
+	]
+}`,
+	`{
+	"count": 3,
+	"next": null,
+	"previous": "%s/api/dcim/devices/?limit=2",
+	"results": [
+		{
+			"id": 3,
+			"name": null,
+			"primary_ip": {"id": 12, "address": "10.0.0.3/24"}
+		}
+	]
+}`,
+}
+
+func TestHTTPSource(t *testing.T) {
+	requests := 0
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.Header.Get("Authorization") != "Token secret" {
+			http.Error(w, `{"detail": "Invalid token"}`, http.StatusForbidden)
+			return
+		}
+		if r.URL.Path != "/api/dcim/devices/" {
+			http.NotFound(w, r)
+			return
+		}
+		page := testHTTPPages[0]
+		if r.URL.Query().Get("offset") == "2" {
+			page = testHTTPPages[1]
+		}
+		fmt.Fprintf(w, page, server.URL)
+	}))
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "inca-http")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	pluginCacheDir = filepath.Join(dir, "cache")
+	defer func() { pluginCacheDir = "" }()
+	os.Setenv("INCA_TEST_TOKEN", "secret")
+	defer os.Unsetenv("INCA_TEST_TOKEN")
+
+	config := `
+[global]
+remote_user = peter
+
+@http token=env:INCA_TEST_TOKEN group_by=role.slug map=serial:serial cache_ttl=5m ` + server.URL + `/api/dcim/devices/
+
+[core]
+core1 platform=ios
+`
+	for i := 0; i < 2; i++ {
+		list, err := ParseString(config)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(list.Groups["dcim"].Devices) != 2 {
+			t.Errorf("incorrect number of devices in group dcim. Expected 2, got %d", len(list.Groups["dcim"].Devices))
+		}
+		if len(list.Devices) != 2 {
+			t.Errorf("devices without a name should be skipped. Got %d devices", len(list.Devices))
+		}
+
+		core1 := list.Devices["core1"]
+		settings := map[string]string{
+			"address":     "10.0.0.1",
+			"site":        "hq",
+			"serial":      "AB123",
+			"remote_user": "peter",
+			// Settings in the inventory file take precedence
+			"platform": "ios",
+		}
+		for setting, expected := range settings {
+			if value := core1.GetSetting(setting); value != expected {
+				t.Errorf("incorrect setting %s of core1. Expected \"%s\", got \"%s\"", setting, expected, value)
+			}
+		}
+		if tags := core1.Tags(); strings.Join(tags, ",") != "edge,pci" {
+			t.Errorf("incorrect tags of core1: %v", tags)
+		}
+		if groups := strings.Join(core1.Groups, ","); groups != "core,dcim" {
+			t.Errorf("incorrect groups of core1: %s", groups)
+		}
+
+		access1 := list.Devices["access1"]
+		if access1.GetSetting("address") != "" || access1.GetSetting("platform") != "ios" {
+			t.Errorf("incorrect settings of access1: %v", access1.getAllSettings())
+		}
+		if _, exists := list.Groups["access"]; !exists {
+			t.Error("group access should be made from the role of access1")
+		}
+	}
+
+	// The second load uses the cached results
+	if requests != 2 {
+		t.Errorf("incorrect number of requests. Expected 2, got %d", requests)
+	}
+
+	_, err = ParseString("@http token=wrong " + server.URL + "/api/dcim/devices/\n")
+	if err == nil || !strings.Contains(err.Error(), "403") {
+		t.Errorf("a rejected token should return an error, got %v", err)
+	}
+
+	for _, line := range []string{
+		"@http " + server.URL + "/api/dcim/devices/ extra",
+		"@http ftp://netbox/api/dcim/devices/",
+		"@http map=serial " + server.URL,
+		"@http color=blue " + server.URL,
+	} {
+		if _, err := ParseString(line + "\n"); err == nil {
+			t.Errorf("%s should return an error", line)
+		}
+	}
+}
+
+func TestHTTPSourceLiteral(t *testing.T) {
+	dir, err := ioutil.TempDir("", "inca-http")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	marker := filepath.Join(dir, "ran")
+	os.Setenv("INCA_TEST_SECRET", "hunter2")
+	defer os.Unsetenv("INCA_TEST_SECRET")
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"next": null, "results": [{"name": "core1", "serial": "cmd:touch %s", "site": {"slug": "env:INCA_TEST_SECRET"}}]}`, marker)
+	}))
+	defer server.Close()
+
+	list, err := ParseString("[global]\nremote_password = env:INCA_TEST_SECRET\n\n@http map=serial:serial " + server.URL + "\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := list.ResolveSettings(); err != nil {
+		t.Fatal(err)
+	}
+
+	core1 := list.Devices["core1"]
+	if value := core1.GetSetting("serial"); value != "cmd:touch "+marker {
+		t.Errorf("remote value should be unchanged, got \"%s\"", value)
+	}
+	if value := core1.GetSetting("site"); value != "env:INCA_TEST_SECRET" {
+		t.Errorf("remote value should be unchanged, got \"%s\"", value)
+	}
+	if all := core1.GetAllSettings(); all["serial"] != "cmd:touch "+marker {
+		t.Errorf("remote value should be unchanged in all settings, got \"%s\"", all["serial"])
+	}
+	if _, err := os.Stat(marker); err == nil {
+		t.Error("a remote cmd: value was run")
+	}
+	if core1.IsExternal("serial") {
+		t.Error("a remote value shouldn't be external")
+	}
+	// Settings in the inventory file are still resolved
+	if value := core1.GetSetting("remote_password"); value != "hunter2" {
+		t.Errorf("local setting should be resolved, got \"%s\"", value)
+	}
+}
+
+func TestHTTPSourceNextServer(t *testing.T) {
+	tokens := make(chan string, 1)
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tokens <- r.Header.Get("Authorization")
+		fmt.Fprint(w, `{"next": null, "results": []}`)
+	}))
+	defer other.Close()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"next": "%s/api/dcim/devices/?offset=1", "results": [{"name": "core1"}]}`, other.URL)
+	}))
+	defer server.Close()
+
+	_, err := ParseString("@http token=secret " + server.URL + "/api/dcim/devices/\n")
+	if err == nil || !strings.Contains(err.Error(), "not the source's server") {
+		t.Errorf("a next page on another server should return an error, got %v", err)
+	}
+	select {
+	case token := <-tokens:
+		t.Errorf("the token was sent to another server: %s", token)
+	default:
+	}
+
+	// Relative next links are resolved against the source
+	requests := 0
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.URL.Query().Get("offset") == "" {
+			fmt.Fprint(w, `{"next": "/api/dcim/devices/?offset=1", "results": [{"name": "core1"}]}`)
+			return
+		}
+		fmt.Fprint(w, `{"next": null, "results": [{"name": "core2"}]}`)
+	}))
+	defer server.Close()
+
+	list, err := ParseString("@http " + server.URL + "/api/dcim/devices/\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Devices) != 2 || requests != 2 {
+		t.Errorf("incorrect devices from relative next link: %d devices in %d requests", len(list.Devices), requests)
+	}
+}
@@ -0,0 +1,230 @@
+package devices
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Lint issue levels. Errors are problems that change how the inventory is read,
+// warnings are likely mistakes.
+const (
+	LintError   = "error"
+	LintWarning = "warning"
+)
+
+// LintIssue is a problem found in an inventory
+type LintIssue struct {
+	Level   string `json:"level"`
+	Message string `json:"message"`
+}
+
+// knownSettings are the settings used by Inca Tool. Any other setting is only used in templates.
+var knownSettings = []string{
+	"address",
+	"cisco_enable",
+	"disabled",
+	"disabled_reason",
+	"host_key_checking",
+	"port",
+	"priority",
+	"protected",
+	"protocol",
+	"proxy_jump",
+	"proxy_password",
+	"proxy_user",
+	"remote_password",
+	"remote_user",
+	"ssh_key",
+	"ssh_key_passphrase",
+	"ssh_port",
+	"tags",
+	"telnet_port",
+}
+
+func (d *DeviceList) addIssue(level, format string, a ...interface{}) {
+	d.issues = append(d.issues, LintIssue{Level: level, Message: fmt.Sprintf(format, a...)})
+}
+
+// Lint returns problems with the inventory that don't stop it from being used. Problems
+// found while parsing are returned first followed by problems with settings, addresses,
+// and groups.
+func Lint(dl *DeviceList) []LintIssue {
+	issues := make([]LintIssue, len(dl.issues))
+	copy(issues, dl.issues)
+	add := func(level, format string, a ...interface{}) {
+		issues = append(issues, LintIssue{Level: level, Message: fmt.Sprintf(format, a...)})
+	}
+
+	groupNames := make([]string, 0, len(dl.Groups))
+	for name := range dl.Groups {
+		groupNames = append(groupNames, name)
+	}
+	sort.Strings(groupNames)
+
+	deviceNames := make([]string, 0, len(dl.Devices))
+	for name := range dl.Devices {
+		deviceNames = append(deviceNames, name)
+	}
+	sort.Strings(deviceNames)
+
+	// Settings
+	for _, name := range groupNames {
+		where := "group " + name
+		if name == "global" {
+			where = "the global group"
+		}
+		for _, issue := range lintSettings(dl.Groups[name].settings) {
+			add(issue.Level, "%s in %s", issue.Message, where)
+		}
+	}
+	for _, name := range deviceNames {
+		for _, issue := range lintSettings(dl.Devices[name].settings) {
+			add(issue.Level, "%s on device %s", issue.Message, name)
+		}
+	}
+
+	// Addresses
+	addresses := make(map[string][]string)
+	for _, name := range deviceNames {
+		address, _ := dl.Devices[name].SettingSource("address")
+		if address == "" {
+			address = name
+		}
+		addresses[address] = append(addresses[address], name)
+	}
+	addressList := make([]string, 0, len(addresses))
+	for address := range addresses {
+		addressList = append(addressList, address)
+	}
+	sort.Strings(addressList)
+	for _, address := range addressList {
+		if names := addresses[address]; len(names) > 1 {
+			add(LintError, "Devices %s have the same address %s", strings.Join(names, ", "), address)
+		}
+	}
+
+	// Groups
+	for _, name := range groupNames {
+		group := dl.Groups[name]
+		if name != "global" && len(group.Devices) == 0 && len(group.Children) == 0 {
+			add(LintWarning, "Group %s has no devices or child groups", name)
+		}
+	}
+	for _, name := range deviceNames {
+		device := dl.Devices[name]
+		useful := false
+		for _, groupName := range device.Groups {
+			group := dl.Groups[groupName]
+			if len(group.Devices) > 1 || len(group.settings) > 0 || len(group.parents) > 0 {
+				useful = true
+				break
+			}
+		}
+		if !useful {
+			add(LintWarning, "Device %s is only in groups with no other devices, settings, or parent groups", name)
+		}
+	}
+
+	return issues
+}
+
+// lintSettings checks the names and values of settings. Names close to a built-in setting are
+// only warnings since templates may use their own settings.
+func lintSettings(settings map[string]string) []LintIssue {
+	keys := make([]string, 0, len(settings))
+	for key := range settings {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var problems []LintIssue
+	problem := func(level, format string, args ...interface{}) {
+		problems = append(problems, LintIssue{level, fmt.Sprintf(format, args...)})
+	}
+	for _, key := range keys {
+		value := settings[key]
+		if suggestion := misspelledSetting(key); suggestion != "" {
+			problem(LintWarning, "Setting %s may be a misspelling of %s", key, suggestion)
+			continue
+		}
+		if isExternal(value) {
+			continue
+		}
+
+		switch key {
+		case "protocol":
+			for _, proto := range strings.Split(strings.Replace(value, " ", "", -1), ",") {
+				if proto != "ssh" && proto != "telnet" {
+					problem(LintError, "Protocol %s isn't supported", proto)
+				}
+			}
+		case "host_key_checking":
+			if value != "tofu" && value != "strict" && value != "off" {
+				problem(LintError, "Setting host_key_checking must be tofu, strict, or off, not %s", value)
+			}
+		case "disabled", "protected":
+			if _, err := parseFlag(value); err != nil {
+				problem(LintError, "Setting %s must be true or false, not %s", key, value)
+			}
+		case "port", "ssh_port", "telnet_port":
+			if port, err := strconv.Atoi(value); err != nil || port < 1 || port > 65535 {
+				problem(LintError, "Setting %s must be a port number, not %s", key, value)
+			}
+		}
+	}
+	return problems
+}
+
+// misspelledSetting returns the known setting key is likely a misspelling of. An
+// empty string is returned if key is known or isn't close to a known setting.
+func misspelledSetting(key string) string {
+	for _, known := range knownSettings {
+		if key == known {
+			return ""
+		}
+	}
+	for _, known := range knownSettings {
+		// Short names are only allowed one mistake so they aren't confused with other words
+		allowed := 1
+		if len(known) >= 8 {
+			allowed = 2
+		}
+		if editDistance(strings.ToLower(key), known) <= allowed {
+			return known
+		}
+	}
+	return ""
+}
+
+// editDistance returns the Levenshtein distance between a and b
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			curr[j] = min3(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(b)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
@@ -0,0 +1,84 @@
+package devices
+
+import (
+	"strings"
+	"testing"
+)
+
+var testLintConfig = `
+[global]
+remote_user = peter
+remote_password cottentail
+
+[core] remote_usr=admin
+core1 address=10.0.0.1 protocol=ssh,telent
+core2 address=10.0.0.1 ssh_port=22a
+
+[access]
+core1 address=10.0.0.9
+sw1 address=10.0.0.2 telnet
+sw2 address=10.0.0.3 protected=maybe
+
+[core] remote_user=other
+
+[lonely]
+sw9
+
+[empty]
+
+[all:children]
+core
+access
+`
+
+func TestLint(t *testing.T) {
+	list, err := ParseString(testLintConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		"error: Text in the global group isn't a setting and is ignored: remote_password cottentail",
+		"error: Settings on the second declaration of device core1 in group access are ignored",
+		"error: Text after device sw1 isn't a setting and is ignored: telnet",
+		"error: Settings on the second declaration of group core are ignored",
+		"warning: Setting remote_usr may be a misspelling of remote_user in group core",
+		"error: Protocol telent isn't supported on device core1",
+		"error: Setting ssh_port must be a port number, not 22a on device core2",
+		"error: Setting protected must be true or false, not maybe on device sw2",
+		"error: Devices core1, core2 have the same address 10.0.0.1",
+		"warning: Group empty has no devices or child groups",
+		"warning: Device sw9 is only in groups with no other devices, settings, or parent groups",
+	}
+
+	issues := Lint(list)
+	got := make([]string, len(issues))
+	for i, issue := range issues {
+		got[i] = issue.Level + ": " + issue.Message
+	}
+	if strings.Join(got, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("incorrect lint issues. Expected:\n%s\nGot:\n%s", strings.Join(expected, "\n"), strings.Join(got, "\n"))
+	}
+
+	list, _ = ParseString(testConfig)
+	for _, issue := range Lint(list) {
+		if issue.Level == LintError {
+			t.Errorf("unexpected lint error: %s", issue.Message)
+		}
+	}
+
+	// Settings used by templates aren't errors even when they're close to a built-in setting
+	list, err = ParseString(`
+[core] tag=edge sort=1 ssh_host=core.example.com
+core1 address=10.0.0.1 cisco_enable2=secret proxy_host2=bastion2
+core2 address=10.0.0.2
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, issue := range Lint(list) {
+		if issue.Level == LintError {
+			t.Errorf("custom setting shouldn't be an error: %s", issue.Message)
+		}
+	}
+}
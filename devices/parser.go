@@ -5,30 +5,64 @@ import (
 	"bytes"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 )
 
 var (
-	groupNameRegex   = regexp.MustCompile(`^\[([\w\- ]+?)\]`)
-	lineSettingRegex = regexp.MustCompile(`([\w\-]+?) ?[=:] ?(?:([^"]\S+)|(?:"((?:[^\\"]|\\\\|\\")+)"))`)
+	groupNameRegex      = regexp.MustCompile(`^\[([\w\- ]+?)(:children)?\]`)
+	lineSettingKeyRegex = regexp.MustCompile(`^[\w\-]+$`)
+	lineSettingRegex    = regexp.MustCompile(`([\w\-]+?) ?[=:] ?(?:(\w+:"(?:[^\\"]|\\\\|\\")+")|([^"\s]\S*)|(?:"((?:[^\\"]|\\\\|\\")*)"))`)
 )
 
+// ParseFile reads an inventory file. The format is given by a "# format: yaml" header on the
+// first line or the file extension. Files ending in .yml or .yaml are read as YAML, .json as
+// JSON, and anything else in the standard format.
 func ParseFile(filename string) (*DeviceList, error) {
 	filename, _ = filepath.Abs(filename)
+	devices, err := readInventoryFile(filename, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := loadSources(devices, globalSources); err != nil {
+		return nil, err
+	}
+	if err := loadVarsDirectories(devices, filepath.Dir(filename)); err != nil {
+		return nil, err
+	}
+	if err := linkGroups(devices); err != nil {
+		return nil, err
+	}
+	return devices, nil
+}
+
+// readInventoryFile parses an inventory file of any format. Child groups are not linked
+// so the groups may be merged into another list. The stack is the files that included
+// this one and is used to find include cycles.
+func readInventoryFile(filename string, stack []string) (*DeviceList, error) {
 	if _, err := os.Stat(filename); os.IsNotExist(err) {
 		return nil, fmt.Errorf("Inventory file does not exist: %s\n", filename)
 	}
 
-	file, err := os.Open(filename)
+	data, err := ioutil.ReadFile(filename)
 	if err != nil {
 		return nil, err
 	}
-	defer file.Close()
-	return parse(file, filename)
+
+	switch format := inventoryFormat(filename, data); format {
+	case "yaml", "json":
+		return parseStructured(data, format, filename, stack)
+	case "ini":
+		return parseINI(bytes.NewReader(data), filename, stack)
+	default:
+		return nil, fmt.Errorf("Unknown inventory format %s in %s\n", format, filename)
+	}
 }
 
 func ParseString(data string) (*DeviceList, error) {
@@ -36,19 +70,37 @@ func ParseString(data string) (*DeviceList, error) {
 }
 
 func parse(reader io.Reader, filename string) (*DeviceList, error) {
-	resolved, err := resolveIncludes(reader, filename)
+	devices, err := parseINI(reader, filename, nil)
 	if err != nil {
 		return nil, err
 	}
+	if err := linkGroups(devices); err != nil {
+		return nil, err
+	}
+	return devices, nil
+}
+
+// parseINI parses an inventory in the standard format. The stack is the files that included
+// this one and is used to find include cycles.
+func parseINI(reader io.Reader, filename string, stack []string) (*DeviceList, error) {
+	includes := &includeResolver{stack: stack}
+	if filename != "" {
+		includes.stack = append(includes.stack, filename)
+	}
+	if err := includes.resolve(reader, filename); err != nil {
+		return nil, err
+	}
 
-	scanner := bufio.NewScanner(resolved)
+	scanner := bufio.NewScanner(&includes.buf)
 	scanner.Split(bufio.ScanLines)
 	devices := &DeviceList{
 		Groups:  make(map[string]*Group),
 		Devices: make(map[string]*Device),
+		issues:  includes.issues,
 	}
 	lineNum := 0
 	currentGroup := ""
+	inChildren := false
 
 	for scanner.Scan() {
 		line := scanner.Bytes()
@@ -62,58 +114,111 @@ func parse(reader io.Reader, filename string) (*DeviceList, error) {
 
 		// Start of group definition
 		if line[0] == '[' {
-			groupLine := groupNameRegex.FindAllSubmatch(line, -1)
+			groupLine := groupNameRegex.FindSubmatch(line)
 			if len(groupLine) == 0 {
-				return nil, fmt.Errorf("Error defining group on line %d\n", lineNum)
+				return nil, fmt.Errorf("%s: Error defining group\n", includes.origin(lineNum))
+			}
+			currentGroup = string(groupLine[1])
+			inChildren = len(groupLine[2]) > 0
+			if inChildren && currentGroup == "global" {
+				return nil, fmt.Errorf("%s: Global group cannot have child groups\n", includes.origin(lineNum))
 			}
-			currentGroup = string(groupLine[0][1])
 			// Check that group name doesn't conflict
 			if _, exists := devices.Devices[currentGroup]; exists {
-				return nil, fmt.Errorf("Can't define a group with the same name as a device. Line %d\n", lineNum)
+				return nil, fmt.Errorf("%s: Can't define a group with the same name as a device\n", includes.origin(lineNum))
 			}
+			rest := bytes.TrimSpace(line[len(groupLine[0]):])
 			if _, exists := devices.Groups[currentGroup]; exists {
+				if len(rest) > 0 {
+					devices.addIssue(LintError, "Settings on the second declaration of group %s are ignored", currentGroup)
+				}
 				// If the group already exists, just set the current group and go on
 				continue
 			}
 			// If the group doesn't exist, create a new group
 			devices.Groups[currentGroup] = &Group{
 				Name:     currentGroup,
-				settings: getLineSettings(line),
+				settings: getLineSettings(rest),
 				list:     devices,
+				origin:   includes.origin(lineNum),
 			}
+			if includes.origin(lineNum).script {
+				devices.Groups[currentGroup].literal = literalKeys(devices.Groups[currentGroup].settings)
+			}
+			if left := unreadSettings(rest); left != "" {
+				devices.addIssue(LintError, "Text after group %s isn't a setting and is ignored: %s", currentGroup, left)
+			}
+			continue
+		}
+
+		// Each line of a children section is the name of a group
+		if inChildren {
+			group := devices.Groups[currentGroup]
+			group.Children = append(group.Children, string(line))
 			continue
 		}
 
 		// The "global" group can only have key = value lines, no device definitions
 		if currentGroup == "global" {
 			settings := getLineSettings(line)
+			if left := unreadSettings(line); left != "" {
+				devices.addIssue(LintError, "Text in the global group isn't a setting and is ignored: %s", left)
+			}
 			for key, value := range settings {
 				devices.Groups[currentGroup].settings[key] = value
+				devices.Groups[currentGroup].setLiteral(key, includes.origin(lineNum).script)
 			}
 			continue
 		}
 
 		// Check for empty group
 		if currentGroup == "" {
-			return nil, fmt.Errorf("All devices must be inside a group. Line %d\n", lineNum)
+			return nil, fmt.Errorf("%s: All devices must be inside a group\n", includes.origin(lineNum))
 		}
 
 		splitLine := bytes.SplitN(line, []byte(" "), 2)
-		deviceName := string(splitLine[0])
+		names, err := expandRanges(string(splitLine[0]))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s\n", includes.origin(lineNum), err.Error())
+		}
 
-		// Add device
-		if dev, exists := devices.Devices[deviceName]; exists {
-			dev.Groups = append(dev.Groups, currentGroup)
-			devices.Groups[currentGroup].Devices = append(devices.Groups[currentGroup].Devices, dev)
-		} else {
+		// Settings are only read after the device name so ranges in the name aren't mistaken for settings
+		var settings []map[string]string
+		if len(splitLine) > 1 {
+			settings, err = getExpandedLineSettings(splitLine[1], len(names))
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s\n", includes.origin(lineNum), err.Error())
+			}
+			if left := unreadSettings(splitLine[1]); left != "" {
+				devices.addIssue(LintError, "Text after device %s isn't a setting and is ignored: %s", splitLine[0], left)
+			}
+		}
+
+		for i, deviceName := range names {
+			// Add device
+			if dev, exists := devices.Devices[deviceName]; exists {
+				if settings != nil {
+					devices.addIssue(LintError, "Settings on the second declaration of device %s in group %s are ignored", deviceName, currentGroup)
+				}
+				dev.Groups = append(dev.Groups, currentGroup)
+				devices.Groups[currentGroup].Devices = append(devices.Groups[currentGroup].Devices, dev)
+				continue
+			}
 			if _, exists := devices.Groups[deviceName]; exists {
-				return nil, fmt.Errorf("Can't define a device with the same name as a group. Line %d\n", lineNum)
+				return nil, fmt.Errorf("%s: Can't define a device with the same name as a group\n", includes.origin(lineNum))
 			}
 			device := &Device{
 				Name:     deviceName,
-				settings: getLineSettings(line),
+				settings: make(map[string]string),
 				Groups:   []string{currentGroup},
 				list:     devices,
+				origin:   includes.origin(lineNum),
+			}
+			if settings != nil {
+				device.settings = settings[i]
+			}
+			if device.origin.script {
+				device.literal = literalKeys(device.settings)
 			}
 
 			devices.Devices[deviceName] = device
@@ -121,87 +226,353 @@ func parse(reader io.Reader, filename string) (*DeviceList, error) {
 		}
 	}
 
+	// Plugins and HTTP sources are loaded after the file so settings in the file take precedence
+	if err := loadSources(devices, includes.sources); err != nil {
+		return nil, err
+	}
 	return devices, nil
 }
 
+// loadSources merges the devices of each source into the list
+func loadSources(devices *DeviceList, sources []inventorySource) error {
+	for _, source := range sources {
+		loaded, err := source.load()
+		if err != nil {
+			return err
+		}
+		if len(loaded.Devices) == 0 {
+			devices.addIssue(LintError, "%s didn't give any devices", source)
+		}
+		if err := mergeDeviceList(devices, loaded); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// linkGroups checks the child groups of each group exist and don't form a cycle,
+// then sets the parents, depth, and priority of each group. Devices are then added
+// to the dynamic groups they match.
+func linkGroups(devices *DeviceList) error {
+	names := make([]string, 0, len(devices.Groups))
+	for name := range devices.Groups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	// Priority orders groups and match rules choose a group's devices, they aren't
+	// settings of the devices in the group
+	matches := make(map[string]*groupMatch)
+	for _, name := range names {
+		group := devices.Groups[name]
+		match, err := readGroupMatch(group)
+		if err != nil {
+			return err
+		}
+		if match != nil {
+			matches[name] = match
+		}
+
+		priority, exists := group.settings["priority"]
+		if !exists {
+			continue
+		}
+		p, err := strconv.Atoi(priority)
+		if err != nil {
+			return fmt.Errorf("Priority of group %s must be a number\n", name)
+		}
+		group.priority = p
+		delete(group.settings, "priority")
+	}
+
+	for _, name := range names {
+		for _, child := range devices.Groups[name].Children {
+			if child == "global" {
+				return fmt.Errorf("Global group cannot be a child of group %s\n", name)
+			}
+			childGroup, exists := devices.Groups[child]
+			if !exists {
+				return fmt.Errorf("Child group %s of group %s does not exist\n", child, name)
+			}
+			childGroup.parents = append(childGroup.parents, name)
+		}
+	}
+
+	// Walk down from each group, a group seen twice in the same path is a cycle
+	var walk func(g *Group, path []string) error
+	walk = func(g *Group, path []string) error {
+		for i, name := range path {
+			if name == g.Name {
+				return fmt.Errorf("Group cycle found: %s\n", strings.Join(append(path[i:], g.Name), " -> "))
+			}
+		}
+		path = append(path, g.Name)
+		for _, child := range g.Children {
+			if err := walk(devices.Groups[child], path); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for _, name := range names {
+		if err := walk(devices.Groups[name], nil); err != nil {
+			return err
+		}
+	}
+
+	// The depth of a group is the length of the longest chain of parents above it
+	var depth func(g *Group) int
+	depth = func(g *Group) int {
+		d := 0
+		for _, parent := range g.parents {
+			if pd := depth(devices.Groups[parent]) + 1; pd > d {
+				d = pd
+			}
+		}
+		return d
+	}
+	for _, name := range names {
+		devices.Groups[name].depth = depth(devices.Groups[name])
+	}
+
+	addMatchedDevices(devices, matches)
+	return nil
+}
+
 func getLineSettings(line []byte) map[string]string {
+	settings, _ := lineSettings(line)
+	return settings
+}
+
+// lineSettings returns the settings in line and which of them had quoted values
+func lineSettings(line []byte) (map[string]string, map[string]bool) {
 	regLine := lineSettingRegex.FindAllSubmatch(line, -1)
 	sets := make(map[string]string)
+	quoted := make(map[string]bool)
 	for _, setting := range regLine {
 		if len(setting) == 0 {
 			continue
 		}
+		// The value is either prefixed and quoted, unquoted, or quoted
 		value := setting[2]
-		if bytes.Equal(value, []byte("")) {
+		if len(value) == 0 {
 			value = setting[3]
 		}
+		if len(value) == 0 {
+			value = setting[4]
+		}
 		sets[string(setting[1])] = string(value)
+		quoted[string(setting[1])] = len(setting[3]) == 0
+	}
+	return sets, quoted
+}
+
+// unreadSettings returns any text in line that isn't read as a setting
+func unreadSettings(line []byte) string {
+	return strings.Join(strings.Fields(string(lineSettingRegex.ReplaceAll(line, nil))), " ")
+}
+
+// getExpandedLineSettings returns the settings in line for each of count devices. Ranges in
+// unquoted values are expanded and paired with the devices in order.
+func getExpandedLineSettings(line []byte, count int) ([]map[string]string, error) {
+	settings, quoted := lineSettings(line)
+	expanded := make([]map[string]string, count)
+	for i := range expanded {
+		expanded[i] = make(map[string]string, len(settings))
+	}
+
+	for key, value := range settings {
+		values := []string{value}
+		if !quoted[key] {
+			var err error
+			values, err = expandRanges(value)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		if len(values) == 1 {
+			for i := range expanded {
+				expanded[i][key] = values[0]
+			}
+			continue
+		}
+		if len(values) != count {
+			return nil, fmt.Errorf("Setting %s expands to %d values but there are %d devices", key, len(values), count)
+		}
+		for i := range expanded {
+			expanded[i][key] = values[i]
+		}
 	}
-	return sets
+	return expanded, nil
+}
+
+// includeResolver replaces include lines with the contents of the included files or the output
+// of a script. The file and line each resolved line came from is kept so errors can refer to the
+// original file. Plugins and HTTP sources are collected to be loaded once the inventory is parsed.
+type includeResolver struct {
+	buf     bytes.Buffer
+	origins []lineOrigin
+	stack   []string
+	sources []inventorySource
+	issues  []LintIssue
+}
+
+// lineOrigin is the file and line number of a line in the resolved inventory. If script
+// is true, the file is a script and the line is a line of its output. Settings from the
+// output of a script are literal.
+type lineOrigin struct {
+	file   string
+	line   int
+	script bool
+}
+
+func (o lineOrigin) String() string {
+	if o.file == "" {
+		return fmt.Sprintf("line %d", o.line)
+	}
+	return fmt.Sprintf("%s:%d", o.file, o.line)
+}
+
+// origin returns where line lineNum of the resolved inventory came from
+func (ir *includeResolver) origin(lineNum int) lineOrigin {
+	if lineNum < 1 || lineNum > len(ir.origins) {
+		return lineOrigin{line: lineNum}
+	}
+	return ir.origins[lineNum-1]
+}
+
+func (ir *includeResolver) writeLine(line []byte, origin lineOrigin) {
+	ir.buf.Write(line)
+	ir.buf.WriteByte('\n')
+	ir.origins = append(ir.origins, origin)
 }
 
-func resolveIncludes(r io.Reader, filename string) (*bytes.Buffer, error) {
+// resolve reads an inventory from r replacing include lines. Paths in include lines are
+// relative to the directory of filename, or the working directory if filename is empty.
+func (ir *includeResolver) resolve(r io.Reader, filename string) error {
 	scanner := bufio.NewScanner(r)
 	scanner.Split(bufio.ScanLines)
-	buf := &bytes.Buffer{}
 	linenum := 0
 
 	for scanner.Scan() {
 		line := scanner.Bytes()
 		linenum++
+		where := lineOrigin{file: filename, line: linenum}
 
 		if len(line) == 0 || line[0] == '#' {
 			continue
 		}
 
 		if line[0] != '@' {
-			buf.Write(line)
-			buf.WriteString("\n")
+			ir.writeLine(line, where)
 			continue
 		}
 
 		if len(line) == 1 {
-			return nil, fmt.Errorf("Error on line %d in file %s, no path given for include", linenum, filename)
+			return fmt.Errorf("%s: No path given for include", where)
 		}
 
-		if line[1] == '!' {
-			if len(line) == 2 {
-				return nil, fmt.Errorf("Error on line %d in file %s, no path given for script include", linenum, filename)
+		if bytes.HasPrefix(line, []byte("@plugin ")) {
+			plugin, err := parsePluginLine(string(line[len("@plugin "):]), filename)
+			if err != nil {
+				return fmt.Errorf("%s: %s", where, err.Error())
 			}
+			ir.sources = append(ir.sources, plugin)
+			continue
+		}
 
-			incFilename, _ := filepath.Abs(string(line[2:]))
-			output, err := getScriptOutput(incFilename)
+		if bytes.HasPrefix(line, []byte("@http ")) {
+			source, err := parseHTTPLine(string(line[len("@http "):]))
 			if err != nil {
-				return nil, err
+				return fmt.Errorf("%s: %s", where, err.Error())
 			}
-			buf.Write(output)
-			buf.WriteString("\n")
+			ir.sources = append(ir.sources, source)
 			continue
 		}
 
-		incFilename, _ := filepath.Abs(string(line[1:]))
-		if incFilename == filename {
-			return nil, fmt.Errorf("File %s included itself at line %d, skipping", filename, linenum)
+		if line[1] == '!' {
+			if len(line) == 2 {
+				return fmt.Errorf("%s: No path given for script include", where)
+			}
+
+			script := includePath(filename, string(line[2:]))
+			output, err := getScriptOutput(script)
+			if err != nil {
+				return err
+			}
+			if len(bytes.TrimSpace(output)) == 0 {
+				ir.issues = append(ir.issues, LintIssue{LintError, fmt.Sprintf("Script include %s didn't give any output", script)})
+			}
+			for i, outLine := range bytes.Split(output, []byte("\n")) {
+				ir.writeLine(outLine, lineOrigin{script, i + 1, true})
+			}
 			continue
 		}
-		if _, err := os.Stat(incFilename); os.IsNotExist(err) {
-			return nil, fmt.Errorf("Include file does not exist: %s", incFilename)
+
+		pattern := includePath(filename, string(line[1:]))
+		files := []string{pattern}
+		if strings.ContainsAny(pattern, "*?[") {
+			var err error
+			files, err = filepath.Glob(pattern)
+			if err != nil {
+				return fmt.Errorf("%s: Invalid include pattern %s", where, pattern)
+			}
+			if len(files) == 0 {
+				ir.issues = append(ir.issues, LintIssue{LintWarning, fmt.Sprintf("Include pattern %s didn't match any files", pattern)})
+			}
+		} else if _, err := os.Stat(pattern); os.IsNotExist(err) {
+			return fmt.Errorf("%s: Include file does not exist: %s", where, pattern)
 		}
 
-		file, err := os.Open(incFilename)
-		if err != nil {
-			return nil, err
+		for _, incFilename := range files {
+			if err := ir.include(incFilename, where); err != nil {
+				return err
+			}
 		}
-		defer file.Close()
-		i, err := resolveIncludes(file, incFilename)
-		if err != nil {
-			return nil, err
+	}
+	return nil
+}
+
+// include resolves the file incFilename included at where
+func (ir *includeResolver) include(incFilename string, where lineOrigin) error {
+	for i, name := range ir.stack {
+		if name == incFilename {
+			cycle := append(append([]string{}, ir.stack[i:]...), incFilename)
+			return fmt.Errorf("%s: Include cycle found: %s", where, strings.Join(cycle, " -> "))
 		}
-		file.Close()
-		buf.Write(i.Bytes())
-		buf.WriteString("\n")
 	}
-	return buf, nil
+
+	file, err := os.Open(incFilename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	ir.stack = append(ir.stack, incFilename)
+	start := len(ir.origins)
+	if err := ir.resolve(file, incFilename); err != nil {
+		return err
+	}
+	ir.stack = ir.stack[:len(ir.stack)-1]
+
+	if len(ir.origins) == start {
+		ir.issues = append(ir.issues, LintIssue{LintError, fmt.Sprintf("Include file %s is empty", incFilename)})
+	}
+	return nil
+}
+
+// includePath returns the absolute path of an include given in the file parent. Relative
+// paths are relative to the directory of parent, or the working directory if parent is empty.
+func includePath(parent, path string) string {
+	if filepath.IsAbs(path) {
+		return filepath.Clean(path)
+	}
+	if parent == "" {
+		path, _ = filepath.Abs(path)
+		return path
+	}
+	return filepath.Join(filepath.Dir(parent), path)
 }
 
 func getScriptOutput(script string) ([]byte, error) {
@@ -210,9 +581,8 @@ func getScriptOutput(script string) ([]byte, error) {
 	var stderr bytes.Buffer
 	cmd.Stdout = &stdout
 	cmd.Stderr = &stderr
-	err := cmd.Run()
-	if err != nil {
-		return stderr.Bytes(), err
+	if err := cmd.Run(); err != nil {
+		return nil, scriptError(script, err, stderr.Bytes())
 	}
 	return stdout.Bytes(), nil
 }
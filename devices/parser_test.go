@@ -1,6 +1,12 @@
 package devices
 
-import "testing"
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
 
 var testConfig = `
 [global]
@@ -76,3 +82,475 @@ func TestDeviceListParser(t *testing.T) {
 		t.Errorf("incorrect number of group memberships. Expected 2, got %d", len(list.Devices["server1"].Groups))
 	}
 }
+
+func TestDeviceGetAllSettings(t *testing.T) {
+	list, err := ParseString(testConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	settings := list.Devices["server2b"].GetAllSettings()
+	expected := map[string]string{
+		"remote_user":     "peter",
+		"remote_password": "cottentail",
+		"cisco_enable":    "orange_cone",
+	}
+	if len(settings) != len(expected) {
+		t.Errorf("incorrect number of settings. Expected %d, got %d", len(expected), len(settings))
+	}
+	for k, v := range expected {
+		if settings[k] != v {
+			t.Errorf("incorrect setting %s. Expected \"%s\", got \"%s\"", k, v, settings[k])
+		}
+	}
+
+	if list.Devices["server4"].GetAllSettings()["address"] != "10.0.0.4" {
+		t.Errorf("incorrect device address. Expected \"10.0.0.4\", got \"%s\"", list.Devices["server4"].GetAllSettings()["address"])
+	}
+}
+
+func TestTaskSettingsPrecedence(t *testing.T) {
+	list, err := ParseString(testConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	list.SetTaskSettings(map[string]string{
+		"remote_user":  "netops",
+		"cisco_enable": "task_enable",
+	})
+
+	// Task overrides global
+	if list.Devices["server1"].GetSetting("remote_user") != "netops" {
+		t.Errorf("incorrect device setting remote_user. Expected \"netops\", got \"%s\"", list.Devices["server1"].GetSetting("remote_user"))
+	}
+
+	// Device overrides task
+	if list.Devices["server3"].GetSetting("remote_user") != "peter1" {
+		t.Errorf("incorrect device setting remote_user. Expected \"peter1\", got \"%s\"", list.Devices["server3"].GetSetting("remote_user"))
+	}
+
+	// Group overrides task
+	if list.Devices["server1b"].GetSetting("cisco_enable") != "orange_cone" {
+		t.Errorf("incorrect device setting cisco_enable. Expected \"orange_cone\", got \"%s\"", list.Devices["server1b"].GetSetting("cisco_enable"))
+	}
+
+	if list.Devices["server1"].GetAllSettings()["cisco_enable"] != "task_enable" {
+		t.Errorf("incorrect device setting cisco_enable. Expected \"task_enable\", got \"%s\"", list.Devices["server1"].GetAllSettings()["cisco_enable"])
+	}
+}
+
+var testNestedConfig = `
+[global]
+remote_user = peter
+
+[server room]
+Server_Switch_1
+Switch2 remote_user=local
+
+[building 1] remote_user=jarvis
+Building1_1
+Building1_2
+
+[access] protocol=telnet
+Building1_2
+
+[all switches:children] remote_user=netops protocol=ssh
+server room
+building 1
+
+[everything:children]
+all switches
+access
+`
+
+func TestNestedGroups(t *testing.T) {
+	list, err := ParseString(testNestedConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(list.Groups["all switches"].Children) != 2 {
+		t.Errorf("incorrect number of child groups. Expected 2, got %d", len(list.Groups["all switches"].Children))
+	}
+
+	if len(list.Groups["everything"].AllDevices()) != 4 {
+		t.Errorf("incorrect number of nested devices. Expected 4, got %d", len(list.Groups["everything"].AllDevices()))
+	}
+
+	// Parent group overrides global
+	if list.Devices["Server_Switch_1"].GetSetting("remote_user") != "netops" {
+		t.Errorf("incorrect device setting remote_user. Expected \"netops\", got \"%s\"", list.Devices["Server_Switch_1"].GetSetting("remote_user"))
+	}
+
+	// Child group overrides parent group
+	if list.Devices["Building1_1"].GetSetting("remote_user") != "jarvis" {
+		t.Errorf("incorrect device setting remote_user. Expected \"jarvis\", got \"%s\"", list.Devices["Building1_1"].GetSetting("remote_user"))
+	}
+
+	// Device overrides all groups
+	if list.Devices["Switch2"].GetSetting("remote_user") != "local" {
+		t.Errorf("incorrect device setting remote_user. Expected \"local\", got \"%s\"", list.Devices["Switch2"].GetSetting("remote_user"))
+	}
+
+	// access and all switches are at the same depth, the group the device is directly in is applied last
+	if list.Devices["Building1_2"].GetSetting("protocol") != "telnet" {
+		t.Errorf("incorrect device setting protocol. Expected \"telnet\", got \"%s\"", list.Devices["Building1_2"].GetSetting("protocol"))
+	}
+
+	if list.Groups["building 1"].GetSetting("protocol") != "ssh" {
+		t.Errorf("incorrect group setting protocol. Expected \"ssh\", got \"%s\"", list.Groups["building 1"].GetSetting("protocol"))
+	}
+
+	filtered, err := Filter(list, []string{"all switches"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(filtered.Devices) != 4 {
+		t.Errorf("incorrect number of filtered devices. Expected 4, got %d", len(filtered.Devices))
+	}
+}
+
+func TestNestedGroupErrors(t *testing.T) {
+	configs := []string{
+		"[a:children]\nb\n",
+		"[a:children]\nb\n[b:children]\nc\n[c:children]\na\n",
+		"[a:children]\nglobal\n",
+		"[global:children]\na\n[a]\nd1\n",
+	}
+	for _, config := range configs {
+		if _, err := ParseString(config); err == nil {
+			t.Errorf("inventory should return an error:\n%s", config)
+		}
+	}
+}
+
+func TestRangeExpansion(t *testing.T) {
+	list, err := ParseString(`
+[access]
+bldg1-sw[01:48].example.com address=10.1.0.[1:48] remote_user=admin
+ap-[a:c][1:2] location="closet [1:2]"
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(list.Devices) != 54 {
+		t.Errorf("incorrect number of devices. Expected 54, got %d", len(list.Devices))
+	}
+
+	device, exists := list.Devices["bldg1-sw07.example.com"]
+	if !exists {
+		t.Fatal("device bldg1-sw07.example.com wasn't expanded")
+	}
+	if device.GetSetting("address") != "10.1.0.7" {
+		t.Errorf("incorrect device address. Expected \"10.1.0.7\", got \"%s\"", device.GetSetting("address"))
+	}
+	if device.GetSetting("remote_user") != "admin" {
+		t.Errorf("incorrect device setting remote_user. Expected \"admin\", got \"%s\"", device.GetSetting("remote_user"))
+	}
+
+	// Quoted values aren't expanded
+	if list.Devices["ap-b2"].GetSetting("location") != "closet [1:2]" {
+		t.Errorf("incorrect device setting location. Expected \"closet [1:2]\", got \"%s\"", list.Devices["ap-b2"].GetSetting("location"))
+	}
+
+	configs := []string{
+		"[a]\nsw[1:4] address=10.0.0.[1:3]\n",
+		"[a]\nsw[4:1]\n",
+		"[a]\nsw[a:5]\n",
+		"[a]\nsw[1:4:0]\n",
+	}
+	for _, config := range configs {
+		if _, err := ParseString(config); err == nil {
+			t.Errorf("inventory should return an error:\n%s", config)
+		}
+	}
+}
+
+func TestExpandRanges(t *testing.T) {
+	tests := map[string]string{
+		"sw[08:10]":   "sw08,sw09,sw10",
+		"sw[8:10]":    "sw8,sw9,sw10",
+		"sw[0:10:5]":  "sw0,sw5,sw10",
+		"[x:z]-[1:2]": "x-1,x-2,y-1,y-2,z-1,z-2",
+		"sw[abc]":     "sw[abc]",
+	}
+	for input, expected := range tests {
+		expanded, err := expandRanges(input)
+		if err != nil {
+			t.Errorf("range %s returned error: %s", input, err)
+			continue
+		}
+		if strings.Join(expanded, ",") != expected {
+			t.Errorf("incorrect expansion of %s. Expected \"%s\", got \"%s\"", input, expected, strings.Join(expanded, ","))
+		}
+	}
+}
+
+func TestSettingSource(t *testing.T) {
+	list, err := ParseString(testNestedConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	list.SetTaskSettings(map[string]string{"cisco_enable": "task_enable"})
+	list.SetOverrides(map[string]string{"remote_password": "ask"})
+
+	tests := []struct {
+		device, setting, value, source string
+	}{
+		{"Building1_1", "remote_user", "jarvis", "group building 1"},
+		{"Server_Switch_1", "remote_user", "netops", "group all switches"},
+		{"Switch2", "remote_user", "local", "device"},
+		{"Switch2", "cisco_enable", "task_enable", "task"},
+		{"Switch2", "remote_password", "ask", "override"},
+		{"Switch2", "address", "", ""},
+	}
+	for _, test := range tests {
+		value, source := list.Devices[test.device].SettingSource(test.setting)
+		if value != test.value || source != test.source {
+			t.Errorf("incorrect source of %s on %s. Expected \"%s\" from \"%s\", got \"%s\" from \"%s\"", test.setting, test.device, test.value, test.source, value, source)
+		}
+	}
+
+	list, _ = ParseString(testConfig)
+	if _, source := list.Devices["server1"].SettingSource("remote_user"); source != "global" {
+		t.Errorf("incorrect source of remote_user. Expected \"global\", got \"%s\"", source)
+	}
+}
+
+var testPriorityConfig = `
+[global]
+remote_user = peter
+proxy_jump = bastion
+
+[zebra]
+sw1
+sw2 a=1
+
+[alpha] remote_user=alpha
+sw1
+sw2
+
+[vendor] remote_user=vendor priority=10
+sw2
+
+[lab] proxy_jump=""
+sw1
+`
+
+func TestGroupPriority(t *testing.T) {
+	list, err := ParseString(testPriorityConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Groups are applied by name when the priority is the same, not by file order
+	if list.Devices["sw1"].GetSetting("remote_user") != "alpha" {
+		t.Errorf("incorrect device setting remote_user. Expected \"alpha\", got \"%s\"", list.Devices["sw1"].GetSetting("remote_user"))
+	}
+
+	// Higher priority groups are applied last
+	if list.Devices["sw2"].GetSetting("remote_user") != "vendor" {
+		t.Errorf("incorrect device setting remote_user. Expected \"vendor\", got \"%s\"", list.Devices["sw2"].GetSetting("remote_user"))
+	}
+
+	// Priority isn't a setting of the device
+	if _, exists := list.Devices["sw2"].GetAllSettings()["priority"]; exists {
+		t.Error("priority should not be a device setting")
+	}
+
+	// An empty value overrides an inherited value
+	if value, source := list.Devices["sw1"].SettingSource("proxy_jump"); value != "" || source != "group lab" {
+		t.Errorf("incorrect device setting proxy_jump. Expected \"\" from \"group lab\", got \"%s\" from \"%s\"", value, source)
+	}
+
+	// Single character values are read
+	if list.Devices["sw2"].GetSetting("a") != "1" {
+		t.Errorf("incorrect device setting a. Expected \"1\", got \"%s\"", list.Devices["sw2"].GetSetting("a"))
+	}
+
+	if _, err := ParseString("[a] priority=high\nsw1\n"); err == nil {
+		t.Error("invalid priority should return an error")
+	}
+}
+
+func TestGroupPriorityDepth(t *testing.T) {
+	list, err := ParseString(`
+[building:children] remote_user=netops priority=100 site=hq
+access
+
+[access] remote_user=jarvis
+sw1
+
+[vendor] site=vendor priority=-5
+sw1
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A higher priority parent never overrides a nested child group
+	if value, source := list.Devices["sw1"].SettingSource("remote_user"); value != "jarvis" || source != "group access" {
+		t.Errorf("incorrect device setting remote_user. Expected \"jarvis\" from \"group access\", got \"%s\" from \"%s\"", value, source)
+	}
+
+	// Building and vendor aren't nested so they're ordered by priority, not by name
+	if value, source := list.Devices["sw1"].SettingSource("site"); value != "hq" || source != "group building" {
+		t.Errorf("incorrect device setting site. Expected \"hq\" from \"group building\", got \"%s\" from \"%s\"", value, source)
+	}
+}
+
+func TestIncludes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "inca-include")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"hosts":              "[global]\nremote_user = peter\n\n@sites/*.conf\n",
+		"sites/a.conf":       "[site a]\nserver1\n@../common/shared.conf\n",
+		"sites/b.conf":       "[site b]\nserver2\n",
+		"common/shared.conf": "[shared]\nserver3\n",
+		"cycle/one":          "[one]\n@two\n",
+		"cycle/two":          "[two]\n@three\n",
+		"cycle/three":        "[three]\n@one\n",
+		"broken/hosts":       "[good]\nserver1\n@bad.conf\n",
+		"broken/bad.conf":    "\n# A comment\n[bad\n",
+	}
+	for name, contents := range files {
+		os.MkdirAll(filepath.Join(dir, filepath.Dir(name)), 0755)
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Includes are relative to the including file, not the working directory
+	cwd, _ := os.Getwd()
+	defer os.Chdir(cwd)
+	os.Chdir(os.TempDir())
+
+	list, err := ParseFile(filepath.Join(dir, "hosts"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, group := range []string{"site a", "site b", "shared"} {
+		if _, exists := list.Groups[group]; !exists {
+			t.Errorf("group %s was not included", group)
+		}
+	}
+	if len(list.Devices) != 3 {
+		t.Errorf("incorrect number of devices. Expected 3, got %d", len(list.Devices))
+	}
+
+	_, err = ParseFile(filepath.Join(dir, "cycle", "one"))
+	if err == nil || !strings.Contains(err.Error(), "Include cycle found") {
+		t.Errorf("include cycle should return an error, got %v", err)
+	}
+
+	// Errors refer to the line in the original file
+	_, err = ParseFile(filepath.Join(dir, "broken", "hosts"))
+	expected := filepath.Join(dir, "broken", "bad.conf") + ":3"
+	if err == nil || !strings.HasPrefix(err.Error(), expected) {
+		t.Errorf("error should start with %s, got %v", expected, err)
+	}
+}
+
+var testDynamicConfig = `
+[global]
+platform = ios
+
+[hq] site=hq
+Building1_1 platform=junos
+Building1_2
+Building2_1 platform=junos tags=edge
+
+[junos] match_setting=platform:junos remote_user=netconf
+[bldg1] match_name=^Building1_
+[hq junos] match_setting=site:"hq" match_name=_1$
+[edge] match_setting=tags:edge
+[none] match_setting=platform:eos
+
+[switches:children]
+bldg1
+`
+
+func TestDynamicGroups(t *testing.T) {
+	list, err := ParseString(testDynamicConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]string{
+		"junos":    "Building1_1,Building2_1",
+		"bldg1":    "Building1_1,Building1_2",
+		"hq junos": "Building1_1,Building2_1",
+		"edge":     "Building2_1",
+		"none":     "",
+	}
+	for group, expected := range tests {
+		names := make([]string, 0)
+		for _, device := range list.Groups[group].Devices {
+			names = append(names, device.Name)
+		}
+		if strings.Join(names, ",") != expected {
+			t.Errorf("incorrect devices in group %s. Expected \"%s\", got \"%s\"", group, expected, strings.Join(names, ","))
+		}
+	}
+
+	if _, exists := list.Groups["junos"].GetSettings()["match_setting"]; exists {
+		t.Error("match_setting should not be a group setting")
+	}
+
+	if list.Devices["Building2_1"].GetSetting("remote_user") != "netconf" {
+		t.Errorf("incorrect device setting remote_user. Expected \"netconf\", got \"%s\"", list.Devices["Building2_1"].GetSetting("remote_user"))
+	}
+
+	// Dynamic groups are selected like any other group
+	filtered, err := Filter(list, []string{"switches"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names := selectedNames(filtered); names != "Building1_1,Building1_2" {
+		t.Errorf("incorrect devices for dynamic child group. Expected \"Building1_1,Building1_2\", got \"%s\"", names)
+	}
+
+	for _, config := range []string{
+		"[a] match_name=[\n",
+		"[a] match_setting=platform\n",
+		"[global] match_name=a\n",
+	} {
+		if _, err := ParseString(config); err == nil {
+			t.Errorf("config should return an error: %q", config)
+		}
+	}
+}
+
+func TestProtectedDevices(t *testing.T) {
+	list, err := ParseString(`
+[core] protected=true
+router1
+router2 protected=false
+
+[access]
+sw1
+sw2 protected=yes
+sw3 protected=off
+sw4 protected=maybe
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A value that isn't understood must never count as unprotected
+	tests := map[string]bool{"router1": true, "router2": false, "sw1": false, "sw2": true, "sw3": false, "sw4": true}
+	for name, expected := range tests {
+		if list.Devices[name].IsProtected() != expected {
+			t.Errorf("incorrect protection for %s. Expected %t", name, expected)
+		}
+	}
+
+	if _, err := list.Devices["sw4"].Flag("protected"); err == nil {
+		t.Error("invalid protected value should return an error")
+	}
+}
@@ -0,0 +1,224 @@
+package devices
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// pluginCacheDir is where plugin and HTTP source output is cached. If empty, a directory in the
+// user's cache directory is used.
+var pluginCacheDir = ""
+
+// inventoryPlugin is an executable that prints an inventory as JSON
+type inventoryPlugin struct {
+	script   string
+	args     []string
+	env      []string
+	cacheTTL time.Duration
+}
+
+// parsePluginLine parses the arguments of a "@plugin [options] script [args]" line.
+// Options are key=value pairs given before the script. The options are cache_ttl
+// and env which may be given multiple times. The script is relative to the directory of filename.
+func parsePluginLine(line, filename string) (*inventoryPlugin, error) {
+	fields, err := splitArgs(line)
+	if err != nil {
+		return nil, err
+	}
+
+	plugin := &inventoryPlugin{}
+	for len(fields) > 0 {
+		parts := strings.SplitN(fields[0], "=", 2)
+		if len(parts) != 2 {
+			break
+		}
+		switch parts[0] {
+		case "cache_ttl":
+			plugin.cacheTTL, err = parseTTL(parts[1])
+			if err != nil {
+				return nil, err
+			}
+		case "env":
+			if !strings.Contains(parts[1], "=") {
+				return nil, fmt.Errorf("Plugin env option must be in the form env=NAME=value")
+			}
+			plugin.env = append(plugin.env, parts[1])
+		default:
+			return nil, fmt.Errorf("Unknown plugin option %s", parts[0])
+		}
+		fields = fields[1:]
+	}
+
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("No script given for plugin")
+	}
+	plugin.script = includePath(filename, fields[0])
+	plugin.args = fields[1:]
+	return plugin, nil
+}
+
+// parseTTL parses a duration such as "5m" or a number of seconds
+func parseTTL(s string) (time.Duration, error) {
+	if seconds, err := strconv.Atoi(s); err == nil {
+		return time.Duration(seconds) * time.Second, nil
+	}
+	ttl, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid cache_ttl %s", s)
+	}
+	return ttl, nil
+}
+
+// splitArgs splits s on whitespace. Double quotes may be used to keep whitespace in an argument.
+func splitArgs(s string) ([]string, error) {
+	var args []string
+	var current bytes.Buffer
+	inArg := false
+	inQuote := false
+
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '"':
+			inQuote = !inQuote
+			inArg = true
+		case c == '\\' && inQuote && i+1 < len(s) && (s[i+1] == '"' || s[i+1] == '\\'):
+			i++
+			current.WriteByte(s[i])
+		case (c == ' ' || c == '\t') && !inQuote:
+			if inArg {
+				args = append(args, current.String())
+				current.Reset()
+				inArg = false
+			}
+		default:
+			current.WriteByte(c)
+			inArg = true
+		}
+	}
+	if inQuote {
+		return nil, fmt.Errorf("Unterminated quote")
+	}
+	if inArg {
+		args = append(args, current.String())
+	}
+	return args, nil
+}
+
+// load runs the plugin, or uses its cached output, and parses the inventory it prints.
+// Child groups are not linked. Settings are literal so they're never resolved from an
+// external source.
+func (p *inventoryPlugin) load() (*DeviceList, error) {
+	devices, err := p.loadOutput()
+	if err != nil {
+		return nil, err
+	}
+	devices.markLiteral()
+	return devices, nil
+}
+
+func (p *inventoryPlugin) loadOutput() (*DeviceList, error) {
+	cacheFile := ""
+	if p.cacheTTL > 0 {
+		cacheFile = p.cacheFile()
+		if cacheFile != "" {
+			if info, err := os.Stat(cacheFile); err == nil && time.Since(info.ModTime()) < p.cacheTTL {
+				if data, err := ioutil.ReadFile(cacheFile); err == nil {
+					if devices, err := parseStructured(data, "json", p.script, nil); err == nil {
+						return devices, nil
+					}
+				}
+			}
+		}
+	}
+
+	output, err := p.run()
+	if err != nil {
+		return nil, err
+	}
+	devices, err := parseStructured(output, "json", p.script, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// Only valid output is cached. The inventory may contain secrets so only the user may read it.
+	if cacheFile != "" {
+		if err := os.MkdirAll(filepath.Dir(cacheFile), 0700); err == nil {
+			ioutil.WriteFile(cacheFile, output, 0600)
+		}
+	}
+	return devices, nil
+}
+
+func (p *inventoryPlugin) run() ([]byte, error) {
+	cmd := exec.Command(p.script, p.args...)
+	cmd.Env = append(os.Environ(), p.env...)
+	var stdout bytes.Buffer
+	var stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, scriptError(p.script, err, stderr.Bytes())
+	}
+	return stdout.Bytes(), nil
+}
+
+// cacheFile returns the file used to cache the plugin's output. The name is a hash
+// of the script, arguments, and environment so each combination is cached separately.
+// An empty string is returned if there's no cache directory.
+func (p *inventoryPlugin) cacheFile() string {
+	dir := inventoryCacheDir()
+	if dir == "" {
+		return ""
+	}
+
+	env := make([]string, len(p.env))
+	copy(env, p.env)
+	sort.Strings(env)
+
+	hash := sha256.New()
+	for _, part := range [][]string{{p.script}, p.args, env} {
+		for _, s := range part {
+			hash.Write([]byte(s))
+			hash.Write([]byte{0})
+		}
+		hash.Write([]byte{1})
+	}
+	return filepath.Join(dir, hex.EncodeToString(hash.Sum(nil))+".json")
+}
+
+// inventoryCacheDir returns the directory where plugin and HTTP source output is cached.
+// An empty string is returned if there's no cache directory.
+func inventoryCacheDir() string {
+	if pluginCacheDir != "" {
+		return pluginCacheDir
+	}
+	userDir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(userDir, "inca-tool", "inventory")
+}
+
+func (p *inventoryPlugin) String() string {
+	return "Plugin " + p.script
+}
+
+// scriptError creates an error for a failed inventory script including what it printed to stderr
+func scriptError(script string, err error, stderr []byte) error {
+	msg := strings.TrimSpace(string(stderr))
+	if msg == "" {
+		return fmt.Errorf("Inventory script %s failed: %s", script, err.Error())
+	}
+	return fmt.Errorf("Inventory script %s failed: %s: %s", script, err.Error(), msg)
+}
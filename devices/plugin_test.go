@@ -0,0 +1,161 @@
+package devices
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var testPluginScript = `#!/bin/sh
+echo run >> "$RUN_LOG"
+cat <<EOF
+{
+	"global": {"remote_user": "ipam"},
+	"groups": {
+		"$1": {
+			"settings": {"site": "$2"},
+			"devices": {"sw1": {"address": "10.0.0.1"}, "sw2": null}
+		}
+	}
+}
+EOF
+`
+
+var testFailingPlugin = `#!/bin/sh
+echo "IPAM is unreachable" >&2
+exit 3
+`
+
+func TestInventoryPlugin(t *testing.T) {
+	dir, err := ioutil.TempDir("", "inca-plugin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	pluginCacheDir = filepath.Join(dir, "cache")
+	defer func() { pluginCacheDir = "" }()
+
+	script := filepath.Join(dir, "ipam.sh")
+	failing := filepath.Join(dir, "failing.sh")
+	runLog := filepath.Join(dir, "runs")
+	ioutil.WriteFile(script, []byte(testPluginScript), 0755)
+	ioutil.WriteFile(failing, []byte(testFailingPlugin), 0755)
+
+	config := `
+[global]
+remote_user = peter
+
+@plugin cache_ttl=5m env=RUN_LOG=` + runLog + ` ` + script + ` "access switches" hq
+
+[core]
+sw1
+`
+	for i := 0; i < 2; i++ {
+		list, err := ParseString(config)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(list.Groups["access switches"].Devices) != 2 {
+			t.Errorf("incorrect number of plugin devices. Expected 2, got %d", len(list.Groups["access switches"].Devices))
+		}
+
+		if len(list.Devices["sw1"].Groups) != 2 {
+			t.Errorf("incorrect number of group memberships. Expected 2, got %d", len(list.Devices["sw1"].Groups))
+		}
+
+		if list.Devices["sw1"].GetSetting("address") != "10.0.0.1" {
+			t.Errorf("incorrect device address. Expected \"10.0.0.1\", got \"%s\"", list.Devices["sw1"].GetSetting("address"))
+		}
+
+		if list.Devices["sw2"].GetSetting("site") != "hq" {
+			t.Errorf("incorrect device setting site. Expected \"hq\", got \"%s\"", list.Devices["sw2"].GetSetting("site"))
+		}
+
+		// Settings in the inventory file take precedence
+		if list.Devices["sw2"].GetSetting("remote_user") != "peter" {
+			t.Errorf("incorrect device setting remote_user. Expected \"peter\", got \"%s\"", list.Devices["sw2"].GetSetting("remote_user"))
+		}
+	}
+
+	// The second parse should use the cache
+	runs, _ := ioutil.ReadFile(runLog)
+	if count := strings.Count(string(runs), "run"); count != 1 {
+		t.Errorf("incorrect number of plugin runs. Expected 1, got %d", count)
+	}
+
+	_, err = ParseString("@plugin " + failing)
+	if err == nil || !strings.Contains(err.Error(), "IPAM is unreachable") {
+		t.Errorf("failing plugin should return its error output, got %v", err)
+	}
+
+	_, err = ParseString("@!" + failing)
+	if err == nil || !strings.Contains(err.Error(), "IPAM is unreachable") {
+		t.Errorf("failing script include should return its error output, got %v", err)
+	}
+
+	for _, line := range []string{"@plugin cache_ttl=soon " + script, "@plugin timeout=5 " + script, "@plugin env=RUN_LOG"} {
+		if _, err := ParseString(line); err == nil {
+			t.Errorf("plugin line should return an error: %s", line)
+		}
+	}
+}
+
+func TestPluginSettingsLiteral(t *testing.T) {
+	dir, err := ioutil.TempDir("", "inca-plugin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	os.Setenv("INCA_TEST_SECRET", "hunter2")
+	defer os.Unsetenv("INCA_TEST_SECRET")
+
+	marker := filepath.Join(dir, "ran")
+	plugin := filepath.Join(dir, "plugin.sh")
+	script := filepath.Join(dir, "script.sh")
+	ioutil.WriteFile(plugin, []byte(`#!/bin/sh
+echo '{"groups": {"ipam": {"settings": {"site": "env:INCA_TEST_SECRET"}, "devices": {"sw1": {"remote_password": "cmd:touch `+marker+`"}}}}}'
+`), 0755)
+	ioutil.WriteFile(script, []byte(`#!/bin/sh
+echo '[global]'
+echo 'enable = env:INCA_TEST_SECRET'
+echo '[generated] site="cmd:touch `+marker+`"'
+echo 'sw2 remote_password=env:INCA_TEST_SECRET'
+`), 0755)
+
+	list, err := ParseString("@plugin " + plugin + "\n@!" + script + "\n[local]\nsw3 remote_password=env:INCA_TEST_SECRET\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := list.ResolveSettings(); err != nil {
+		t.Fatal(err)
+	}
+
+	settings := []struct {
+		device, setting, expected string
+	}{
+		{"sw1", "remote_password", "cmd:touch " + marker},
+		{"sw1", "site", "env:INCA_TEST_SECRET"},
+		{"sw2", "remote_password", "env:INCA_TEST_SECRET"},
+		{"sw2", "site", "cmd:touch " + marker},
+		{"sw2", "enable", "env:INCA_TEST_SECRET"},
+		// Settings written in the inventory file are still resolved
+		{"sw3", "remote_password", "hunter2"},
+	}
+	for _, test := range settings {
+		if value := list.Devices[test.device].GetSetting(test.setting); value != test.expected {
+			t.Errorf("incorrect setting %s of %s. Expected \"%s\", got \"%s\"", test.setting, test.device, test.expected, value)
+		}
+	}
+	if _, err := os.Stat(marker); err == nil {
+		t.Error("a cmd: value from a plugin or script was run")
+	}
+	if list.GetGlobal("enable") != "env:INCA_TEST_SECRET" {
+		t.Errorf("global setting from a script should be unchanged, got \"%s\"", list.GetGlobal("enable"))
+	}
+	if value, _ := list.Groups["generated"].LookupSetting("site"); value != "cmd:touch "+marker {
+		t.Errorf("group setting from a script should be unchanged, got \"%s\"", value)
+	}
+}
@@ -0,0 +1,86 @@
+package devices
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+)
+
+// rangeRegex matches a numeric or alphabetic range such as [01:48], [a:f], or [0:100:10]
+var rangeRegex = regexp.MustCompile(`\[([0-9]+|[a-zA-Z]):([0-9]+|[a-zA-Z])(?::([0-9]+))?\]`)
+
+// expandRanges expands every range in s. A string with multiple ranges expands to every
+// combination of their values. A string without a range is returned as is.
+func expandRanges(s string) ([]string, error) {
+	loc := rangeRegex.FindStringSubmatchIndex(s)
+	if loc == nil {
+		return []string{s}, nil
+	}
+
+	step := ""
+	if loc[6] >= 0 {
+		step = s[loc[6]:loc[7]]
+	}
+	values, err := expandRange(s[loc[2]:loc[3]], s[loc[4]:loc[5]], step)
+	if err != nil {
+		return nil, err
+	}
+	rest, err := expandRanges(s[loc[1]:])
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := s[:loc[0]]
+	expanded := make([]string, 0, len(values)*len(rest))
+	for _, value := range values {
+		for _, suffix := range rest {
+			expanded = append(expanded, prefix+value+suffix)
+		}
+	}
+	return expanded, nil
+}
+
+// expandRange returns the values from start to end inclusive. Numeric ranges keep the
+// zero padding of start.
+func expandRange(start, end, step string) ([]string, error) {
+	inc := 1
+	if step != "" {
+		inc, _ = strconv.Atoi(step)
+		if inc < 1 {
+			return nil, fmt.Errorf("Range step must be greater than zero in [%s:%s:%s]", start, end, step)
+		}
+	}
+
+	startNum, startErr := strconv.Atoi(start)
+	endNum, endErr := strconv.Atoi(end)
+	if startErr == nil && endErr == nil {
+		if startNum > endNum {
+			return nil, fmt.Errorf("Range start is after the end in [%s:%s]", start, end)
+		}
+		width := 0
+		if len(start) > 1 && start[0] == '0' {
+			width = len(start)
+		}
+		var values []string
+		for i := startNum; i <= endNum; i += inc {
+			values = append(values, fmt.Sprintf("%0*d", width, i))
+		}
+		return values, nil
+	}
+
+	if startErr == nil || endErr == nil || len(start) != 1 || len(end) != 1 || isUpper(start[0]) != isUpper(end[0]) {
+		return nil, fmt.Errorf("Range must be numeric or alphabetic in [%s:%s]", start, end)
+	}
+	if start[0] > end[0] {
+		return nil, fmt.Errorf("Range start is after the end in [%s:%s]", start, end)
+	}
+	var values []string
+	for c := int(start[0]); c <= int(end[0]); c += inc {
+		values = append(values, string(rune(c)))
+	}
+	return values, nil
+}
+
+func isUpper(c byte) bool {
+	return c >= 'A' && c <= 'Z'
+}
@@ -0,0 +1,172 @@
+package devices
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// A Resolver returns the real value of a setting that refers to an external source such
+// as "vault:core/admin". It's given the setting name and the value without the prefix.
+type Resolver func(name, arg string) (string, error)
+
+var (
+	resolvers = map[string]Resolver{
+		"cmd": resolveCommand,
+		"env": resolveEnv,
+	}
+	prompts       = make(map[string]bool)
+	prompting     = true
+	resolvedCache = make(map[string]string)
+	resolveLock   sync.Mutex
+)
+
+// RegisterResolver registers a resolver for setting values in the form "prefix:arg"
+func RegisterResolver(prefix string, r Resolver) {
+	resolveLock.Lock()
+	defer resolveLock.Unlock()
+	resolvers[prefix] = r
+}
+
+// RegisterPrompt registers a resolver that asks the user for the value of a setting. Unlike
+// other resolvers, a value that is only the prefix is also resolved with an empty argument.
+func RegisterPrompt(prefix string, r Resolver) {
+	resolveLock.Lock()
+	defer resolveLock.Unlock()
+	resolvers[prefix] = r
+	prompts[prefix] = true
+}
+
+// SetPrompting enables or disables resolvers registered with RegisterPrompt. When disabled,
+// such as for a dry run, settings that would be prompted for resolve to an empty string.
+func SetPrompting(enabled bool) {
+	resolveLock.Lock()
+	defer resolveLock.Unlock()
+	prompting = enabled
+}
+
+// lookupResolver returns the resolver of a value and its argument. Only prompts may be
+// given without a colon. The resolve lock must be held.
+func lookupResolver(value string) (Resolver, string, bool) {
+	i := strings.IndexByte(value, ':')
+	if i <= 0 {
+		if prompts[value] {
+			return resolvers[value], "", true
+		}
+		return nil, "", false
+	}
+	r, ok := resolvers[value[:i]]
+	return r, value[i+1:], ok
+}
+
+// isExternal returns if a value refers to an external source
+func isExternal(value string) bool {
+	resolveLock.Lock()
+	defer resolveLock.Unlock()
+	_, _, ok := lookupResolver(value)
+	return ok
+}
+
+// resolveSetting returns the real value of a setting. Values without a registered prefix
+// are returned unchanged. Resolved values are cached for the life of the process.
+func resolveSetting(name, value string) (string, error) {
+	if value == "" {
+		return value, nil
+	}
+
+	resolveLock.Lock()
+	defer resolveLock.Unlock()
+
+	r, arg, ok := lookupResolver(value)
+	if !ok {
+		return value, nil
+	}
+	if !prompting && prompts[strings.SplitN(value, ":", 2)[0]] {
+		return "", nil
+	}
+
+	cacheKey := name + "=" + value
+	if v, ok := resolvedCache[cacheKey]; ok {
+		return v, nil
+	}
+
+	v, err := r(name, arg)
+	if err != nil {
+		// Errors never contain the value, it could be sensitive
+		return "", fmt.Errorf("Failed to resolve setting %s: %s", name, err.Error())
+	}
+	resolvedCache[cacheKey] = v
+	return v, nil
+}
+
+// ResolveSettings resolves every setting of every device in the list. This should be called
+// before running a task so any errors or prompts happen before devices are configured.
+func (d *DeviceList) ResolveSettings() error {
+	// Resolve in a consistent order so any prompts are always in the same order
+	deviceNames := make([]string, 0, len(d.Devices))
+	for name := range d.Devices {
+		deviceNames = append(deviceNames, name)
+	}
+	sort.Strings(deviceNames)
+
+	for _, deviceName := range deviceNames {
+		device := d.Devices[deviceName]
+		settings := device.getAllSettings()
+		names := make([]string, 0, len(settings))
+		for name := range settings {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			if _, err := device.LookupSetting(name); err != nil {
+				return fmt.Errorf("Device %s: %s", device.Name, err.Error())
+			}
+		}
+	}
+	return nil
+}
+
+// resolveCommand resolves "cmd:command" settings to the standard output of the command.
+// The command is run with sh and may be enclosed in double quotes.
+func resolveCommand(name, command string) (string, error) {
+	command = unquote(command)
+	if command == "" {
+		return "", errors.New("No command given")
+	}
+
+	cmd := exec.Command("sh", "-c", command)
+	var stdout bytes.Buffer
+	var stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("Command failed: %s %s", err.Error(), strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimRight(stdout.String(), "\r\n"), nil
+}
+
+// resolveEnv resolves "env:NAME" settings to the value of the environment variable NAME
+func resolveEnv(name, variable string) (string, error) {
+	value, ok := os.LookupEnv(variable)
+	if !ok {
+		return "", fmt.Errorf("Environment variable %s is not set", variable)
+	}
+	return value, nil
+}
+
+// unquote removes surrounding double quotes and unescapes quotes and backslashes
+func unquote(s string) string {
+	if len(s) < 2 || s[0] != '"' || s[len(s)-1] != '"' {
+		return s
+	}
+	s = s[1 : len(s)-1]
+	s = strings.Replace(s, `\\`, "\x00", -1)
+	s = strings.Replace(s, `\"`, `"`, -1)
+	return strings.Replace(s, "\x00", `\`, -1)
+}
@@ -0,0 +1,180 @@
+package devices
+
+import (
+	"errors"
+	"os"
+	"testing"
+)
+
+func TestResolveSettings(t *testing.T) {
+	resolvedCache = make(map[string]string)
+	calls := 0
+	RegisterResolver("testsecret", func(name, arg string) (string, error) {
+		calls++
+		if arg == "missing" {
+			return "", errors.New("not found")
+		}
+		return "resolved-" + arg, nil
+	})
+
+	list, err := ParseString(`
+[global]
+remote_password = testsecret:global/admin
+site = http://example.com
+
+[core]
+core1 cisco_enable=testsecret:core/enable
+core2 remote_password=testsecret:global/admin
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := list.ResolveSettings(); err != nil {
+		t.Fatal(err)
+	}
+
+	if list.Devices["core1"].GetSetting("remote_password") != "resolved-global/admin" {
+		t.Errorf("incorrect device setting remote_password. Expected \"resolved-global/admin\", got \"%s\"", list.Devices["core1"].GetSetting("remote_password"))
+	}
+	if list.Devices["core1"].GetAllSettings()["cisco_enable"] != "resolved-core/enable" {
+		t.Errorf("incorrect device setting cisco_enable. Expected \"resolved-core/enable\", got \"%s\"", list.Devices["core1"].GetAllSettings()["cisco_enable"])
+	}
+	// Values with an unregistered prefix are left alone
+	if list.Devices["core1"].GetSetting("site") != "http://example.com" {
+		t.Errorf("incorrect device setting site. Expected \"http://example.com\", got \"%s\"", list.Devices["core1"].GetSetting("site"))
+	}
+	// Each distinct value is only resolved once
+	if calls != 2 {
+		t.Errorf("incorrect number of resolver calls. Expected 2, got %d", calls)
+	}
+
+	list.Devices["core2"].settings["remote_password"] = "testsecret:missing"
+	if err := list.ResolveSettings(); err == nil {
+		t.Error("resolving a missing secret succeeded but should have failed")
+	}
+	if list.Devices["core2"].GetSetting("remote_password") != "" {
+		t.Errorf("incorrect device setting remote_password. Expected \"\", got \"%s\"", list.Devices["core2"].GetSetting("remote_password"))
+	}
+}
+
+func TestResolveBarePrefixAndOverrides(t *testing.T) {
+	resolvedCache = make(map[string]string)
+	asked := 0
+	RegisterPrompt("testask", func(name, label string) (string, error) {
+		asked++
+		return name + "/" + label, nil
+	})
+
+	list, err := ParseString(`
+[global]
+remote_password = testask
+
+[core] cisco_enable=testask:tacacs
+core1
+core2 remote_password=testask:tacacs
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := list.ResolveSettings(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		device, setting, expected string
+	}{
+		{"core1", "remote_password", "remote_password/"},
+		{"core1", "cisco_enable", "cisco_enable/tacacs"},
+		{"core2", "remote_password", "remote_password/tacacs"},
+		{"core2", "cisco_enable", "cisco_enable/tacacs"},
+	}
+	for _, test := range tests {
+		if list.Devices[test.device].GetSetting(test.setting) != test.expected {
+			t.Errorf("incorrect device setting %s on %s. Expected \"%s\", got \"%s\"",
+				test.setting, test.device, test.expected, list.Devices[test.device].GetSetting(test.setting))
+		}
+	}
+	// Once per distinct credential
+	if asked != 3 {
+		t.Errorf("incorrect number of prompts. Expected 3, got %d", asked)
+	}
+
+	list.SetOverrides(map[string]string{"remote_password": "override"})
+	if list.Devices["core2"].GetSetting("remote_password") != "override" {
+		t.Errorf("incorrect device setting remote_password. Expected \"override\", got \"%s\"", list.Devices["core2"].GetSetting("remote_password"))
+	}
+}
+
+func TestResolveCommandAndEnv(t *testing.T) {
+	resolvedCache = make(map[string]string)
+	os.Setenv("INCA_TEST_PASS", "env secret")
+	defer os.Unsetenv("INCA_TEST_PASS")
+
+	list, err := ParseString(`
+[core]
+core1 remote_password=cmd:"echo \"cmd secret\"" cisco_enable=env:INCA_TEST_PASS
+core2 remote_password=cmd:"exit 1"
+core3 remote_password=env:INCA_TEST_UNSET
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if list.Devices["core1"].GetSetting("remote_password") != "cmd secret" {
+		t.Errorf("incorrect device setting remote_password. Expected \"cmd secret\", got \"%s\"", list.Devices["core1"].GetSetting("remote_password"))
+	}
+	if list.Devices["core1"].GetSetting("cisco_enable") != "env secret" {
+		t.Errorf("incorrect device setting cisco_enable. Expected \"env secret\", got \"%s\"", list.Devices["core1"].GetSetting("cisco_enable"))
+	}
+	if !list.Devices["core1"].IsExternal("remote_password") {
+		t.Error("remote_password should be external")
+	}
+	if _, err := list.Devices["core2"].LookupSetting("remote_password"); err == nil {
+		t.Error("failed command resolved but should have failed")
+	}
+	if _, err := list.Devices["core3"].LookupSetting("remote_password"); err == nil {
+		t.Error("unset environment variable resolved but should have failed")
+	}
+}
+
+func TestResolvePlainPrefixValues(t *testing.T) {
+	resolvedCache = make(map[string]string)
+	RegisterResolver("testvault", func(name, arg string) (string, error) {
+		return "", errors.New("resolver shouldn't be called")
+	})
+	asked := 0
+	RegisterPrompt("testprompt", func(name, label string) (string, error) {
+		asked++
+		return "answer", nil
+	})
+
+	// Values that are only the name of a resolver other than a prompt are plain values
+	list, err := ParseString(`
+[core]
+core1 banner=testvault x=env mode=cmd remote_password=testprompt
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := list.ResolveSettings(); err != nil {
+		t.Fatal(err)
+	}
+	for setting, expected := range map[string]string{"banner": "testvault", "x": "env", "mode": "cmd", "remote_password": "answer"} {
+		if value := list.Devices["core1"].GetSetting(setting); value != expected {
+			t.Errorf("incorrect device setting %s. Expected \"%s\", got \"%s\"", setting, expected, value)
+		}
+	}
+	if list.Devices["core1"].IsExternal("x") {
+		t.Error("setting x shouldn't be external")
+	}
+
+	// Prompts are skipped when prompting is disabled
+	resolvedCache = make(map[string]string)
+	asked = 0
+	SetPrompting(false)
+	defer SetPrompting(true)
+	if value := list.Devices["core1"].GetSetting("remote_password"); value != "" || asked != 0 {
+		t.Errorf("prompt shouldn't be used when prompting is disabled, got \"%s\" after %d prompts", value, asked)
+	}
+}
@@ -0,0 +1,333 @@
+package devices
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Operators must be surrounded by whitespace so they aren't confused with a regex or glob
+var (
+	unionRegex     = regexp.MustCompile(`\s+\|\s+`)
+	intersectRegex = regexp.MustCompile(`\s+&\s+`)
+
+	whereAndRegex       = regexp.MustCompile(`\s+and\s+`)
+	whereConditionRegex = regexp.MustCompile(`^([\w\-.]+)\s*(!=|=)\s*(.*)$`)
+)
+
+// Filter filters a device list to the devices selected by filter. Each term may be a group or
+// device name, a glob such as "core-*", a regex prefixed with a tilde such as "~^bldg[0-9]+-sw",
+// a tag such as "tag:core", or a query of settings such as "where platform=junos and site=hq".
+// Terms may be combined with " & " for an intersection and " | " for a union. A name or pattern
+// prefixed with an exclamation point selects every device not matched by it. A term that's only
+// a negated name or pattern removes the matching devices from the selection. If every term is
+// an exclusion, all devices are selected before removing them. Disabled devices are never
+// selected, see Disabled.
+func Filter(dl *DeviceList, filter []string) (*DeviceList, error) {
+	source := dl.getSource()
+	selected, err := source.selectDevices(filter)
+	if err != nil {
+		return nil, err
+	}
+	return source.subset(selected), nil
+}
+
+// Limit further restricts a filtered device list to the devices selected by limit.
+// The terms are the same as Filter and are matched against the full inventory.
+func Limit(dl *DeviceList, limit []string) (*DeviceList, error) {
+	source := dl.getSource()
+	selected, err := source.selectDevices(limit)
+	if err != nil {
+		return nil, err
+	}
+	for name, device := range selected {
+		if _, exists := dl.Devices[name]; !exists && !containsDevice(dl.disabled, device) {
+			delete(selected, name)
+		}
+	}
+	return source.subset(selected), nil
+}
+
+// Disabled returns the devices that were selected by Filter or Limit but left out
+// because they're disabled. The devices are sorted by name.
+func (d *DeviceList) Disabled() []*Device {
+	return d.disabled
+}
+
+// getSource returns the full inventory a filtered device list was created from
+func (d *DeviceList) getSource() *DeviceList {
+	if d.source != nil {
+		return d.source
+	}
+	return d
+}
+
+// subset returns a device list containing only the selected devices that aren't disabled.
+// Groups are included if all of their devices were selected.
+func (d *DeviceList) subset(selected map[string]*Device) *DeviceList {
+	devices := &DeviceList{
+		Groups:  make(map[string]*Group),
+		Devices: selected,
+		source:  d,
+	}
+
+	names := make([]string, 0, len(selected))
+	for name := range selected {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if selected[name].IsDisabled() {
+			devices.disabled = append(devices.disabled, selected[name])
+			delete(selected, name)
+		}
+	}
+
+	for name, group := range d.Groups {
+		groupDevices := group.AllDevices()
+		if name == "global" || len(groupDevices) == 0 {
+			continue
+		}
+		all := true
+		for _, device := range groupDevices {
+			if _, exists := selected[device.Name]; !exists {
+				all = false
+				break
+			}
+		}
+		if all {
+			devices.Groups[name] = group
+		}
+	}
+	return devices
+}
+
+func (d *DeviceList) selectDevices(terms []string) (map[string]*Device, error) {
+	selected := make(map[string]*Device)
+	var excluded []map[string]*Device
+	onlyExclusions := true
+
+	for _, term := range terms {
+		term = strings.TrimSpace(term)
+		if term == "" {
+			continue
+		}
+
+		// A lone negated term is an exclusion
+		if term[0] == '!' && !unionRegex.MatchString(term) && !intersectRegex.MatchString(term) {
+			matched, err := d.matchPattern(strings.TrimSpace(term[1:]))
+			if err != nil {
+				return nil, err
+			}
+			excluded = append(excluded, matched)
+			continue
+		}
+
+		onlyExclusions = false
+		matched, err := d.evalSelection(term)
+		if err != nil {
+			return nil, err
+		}
+		for name, device := range matched {
+			selected[name] = device
+		}
+	}
+
+	if onlyExclusions && len(excluded) > 0 {
+		for name, device := range d.Devices {
+			selected[name] = device
+		}
+	}
+
+	for _, matched := range excluded {
+		for name := range matched {
+			delete(selected, name)
+		}
+	}
+	return selected, nil
+}
+
+// evalSelection evaluates an expression of unions and intersections. Intersections are
+// evaluated first.
+func (d *DeviceList) evalSelection(expr string) (map[string]*Device, error) {
+	selected := make(map[string]*Device)
+
+	for _, union := range unionRegex.Split(expr, -1) {
+		var intersection map[string]*Device
+
+		for i, atom := range intersectRegex.Split(union, -1) {
+			matched, err := d.matchAtom(strings.TrimSpace(atom))
+			if err != nil {
+				return nil, err
+			}
+			if i == 0 {
+				intersection = matched
+				continue
+			}
+			for name := range intersection {
+				if _, exists := matched[name]; !exists {
+					delete(intersection, name)
+				}
+			}
+		}
+
+		for name, device := range intersection {
+			selected[name] = device
+		}
+	}
+	return selected, nil
+}
+
+// matchAtom returns the devices matched by a name or pattern which may be negated
+func (d *DeviceList) matchAtom(atom string) (map[string]*Device, error) {
+	if atom == "" || atom == "!" {
+		return nil, errors.New("Empty term in device selection.\n")
+	}
+	if atom[0] != '!' {
+		return d.matchPattern(atom)
+	}
+
+	matched, err := d.matchPattern(strings.TrimSpace(atom[1:]))
+	if err != nil {
+		return nil, err
+	}
+	complement := make(map[string]*Device)
+	for name, device := range d.Devices {
+		if _, exists := matched[name]; !exists {
+			complement[name] = device
+		}
+	}
+	return complement, nil
+}
+
+// matchPattern returns the devices matched by a group or device name, a glob, or a regex.
+// Patterns are matched against both group and device names. A group matches all of its devices.
+func (d *DeviceList) matchPattern(pattern string) (map[string]*Device, error) {
+	if strings.HasPrefix(pattern, "tag:") {
+		return d.matchTag(strings.TrimSpace(pattern[len("tag:"):]))
+	}
+	if strings.HasPrefix(pattern, "where ") {
+		return d.matchWhere(strings.TrimSpace(pattern[len("where "):]))
+	}
+
+	matched := make(map[string]*Device)
+
+	var match func(string) bool
+	if pattern != "" && pattern[0] == '~' {
+		re, err := regexp.Compile(pattern[1:])
+		if err != nil {
+			return nil, fmt.Errorf("Invalid regex \"%s\": %s\n", pattern[1:], err.Error())
+		}
+		match = re.MatchString
+	} else if strings.ContainsAny(pattern, "*?[") {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("Invalid pattern \"%s\".\n", pattern)
+		}
+		match = func(name string) bool {
+			ok, _ := path.Match(pattern, name)
+			return ok
+		}
+	} else {
+		// Check for a group
+		if group, exists := d.Groups[pattern]; exists {
+			if pattern == "global" {
+				return nil, errors.New("Global group cannot be used.\n")
+			}
+			for _, device := range group.AllDevices() {
+				matched[device.Name] = device
+			}
+			return matched, nil
+		}
+		// Check device
+		if device, exists := d.Devices[pattern]; exists {
+			matched[pattern] = device
+			return matched, nil
+		}
+		return nil, fmt.Errorf("Group or device \"%s\" not found.\n", pattern)
+	}
+
+	for name, group := range d.Groups {
+		if name == "global" || !match(name) {
+			continue
+		}
+		for _, device := range group.AllDevices() {
+			matched[device.Name] = device
+		}
+	}
+	for name, device := range d.Devices {
+		if match(name) {
+			matched[name] = device
+		}
+	}
+	return matched, nil
+}
+
+// matchTag returns the devices with a tag matching pattern. The pattern may be a glob.
+func (d *DeviceList) matchTag(pattern string) (map[string]*Device, error) {
+	if pattern == "" {
+		return nil, errors.New("No tag given in device selection.\n")
+	}
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, fmt.Errorf("Invalid pattern \"%s\".\n", pattern)
+	}
+
+	matched := make(map[string]*Device)
+	for name, device := range d.Devices {
+		for _, tag := range device.Tags() {
+			if ok, _ := path.Match(pattern, tag); ok {
+				matched[name] = device
+				break
+			}
+		}
+	}
+	return matched, nil
+}
+
+// matchWhere returns the devices matching a query such as "platform=junos and site!=hq".
+// Conditions are compared to the settings of each device after applying the order of precedence.
+// Values may be globs or quoted. An empty value matches devices without the setting.
+func (d *DeviceList) matchWhere(query string) (map[string]*Device, error) {
+	if query == "" {
+		return nil, errors.New("No conditions given in where query.\n")
+	}
+
+	type condition struct {
+		key, value string
+		negate     bool
+	}
+	var conditions []condition
+	for _, part := range whereAndRegex.Split(query, -1) {
+		m := whereConditionRegex.FindStringSubmatch(strings.TrimSpace(part))
+		if m == nil {
+			return nil, fmt.Errorf("Invalid condition \"%s\", expected setting=value or setting!=value.\n", part)
+		}
+		value := strings.TrimSpace(m[3])
+		if len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"' {
+			value = value[1 : len(value)-1]
+		}
+		if _, err := path.Match(value, ""); err != nil {
+			return nil, fmt.Errorf("Invalid pattern \"%s\".\n", value)
+		}
+		conditions = append(conditions, condition{key: m[1], value: value, negate: m[2] == "!="})
+	}
+
+	matched := make(map[string]*Device)
+	for name, device := range d.Devices {
+		all := true
+		for _, c := range conditions {
+			// External values aren't resolved so a selection never reads secrets
+			ok, _ := path.Match(c.value, device.getSetting(c.key))
+			if ok == c.negate {
+				all = false
+				break
+			}
+		}
+		if all {
+			matched[name] = device
+		}
+	}
+	return matched, nil
+}
@@ -0,0 +1,202 @@
+package devices
+
+import (
+	"sort"
+	"strings"
+	"testing"
+)
+
+func selectedNames(dl *DeviceList) string {
+	names := make([]string, 0, len(dl.Devices))
+	for name := range dl.Devices {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ",")
+}
+
+func TestFilter(t *testing.T) {
+	list, err := ParseString(testConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		filter   []string
+		expected string
+	}{
+		{[]string{"boston co-location"}, "server1,server2,server3,server4"},
+		{[]string{"server1b", "web app"}, "server1,server1b,server2b"},
+		{[]string{"server?b"}, "server1b,server2b"},
+		{[]string{"*location"}, "server1,server1b,server2,server2b,server3,server4"},
+		{[]string{"~^server[23]$"}, "server2,server3"},
+		{[]string{"boston co-location", "!server2", "!server3"}, "server1,server4"},
+		{[]string{"!boston co-location"}, "server1b,server2b"},
+		{[]string{"boston co-location & web app"}, "server1"},
+		{[]string{"san fran location | server4"}, "server1b,server2b,server4"},
+		{[]string{"boston co-location & !web app | server1b"}, "server1b,server2,server3,server4"},
+	}
+
+	for _, test := range tests {
+		filtered, err := Filter(list, test.filter)
+		if err != nil {
+			t.Errorf("filter %q returned error: %s", test.filter, err)
+			continue
+		}
+		if names := selectedNames(filtered); names != test.expected {
+			t.Errorf("incorrect devices for filter %q. Expected \"%s\", got \"%s\"", test.filter, test.expected, names)
+		}
+	}
+
+	for _, filter := range []string{"global", "server9", "~[", "[a", "server1 & "} {
+		if _, err := Filter(list, []string{filter}); err == nil {
+			t.Errorf("filter %q should return an error", filter)
+		}
+	}
+}
+
+func TestLimit(t *testing.T) {
+	list, err := ParseString(testConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	filtered, err := Filter(list, []string{"boston co-location"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, exists := filtered.Groups["boston co-location"]; !exists {
+		t.Error("fully selected group should be in the filtered list")
+	}
+
+	// The limit is matched against the full inventory
+	limited, err := Limit(filtered, []string{"web app", "!server4"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names := selectedNames(limited); names != "server1" {
+		t.Errorf("incorrect limited devices. Expected \"server1\", got \"%s\"", names)
+	}
+
+	limited, err = Limit(filtered, []string{"!server2 & !server3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names := selectedNames(limited); names != "server1,server4" {
+		t.Errorf("incorrect limited devices. Expected \"server1,server4\", got \"%s\"", names)
+	}
+}
+
+var testTagConfig = `
+[global]
+platform = ios
+tags = managed
+
+[hq] site=hq tags=core
+sw1 platform=junos tags=edge,pci
+sw2
+sw3 platform=junos tags=" pci "
+
+[branch] site="branch 1"
+sw4 tags=edge
+sw5 site=""
+`
+
+func TestTagsAndWhere(t *testing.T) {
+	list, err := ParseString(testTagConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if tags := strings.Join(list.Devices["sw1"].Tags(), ","); tags != "core,edge,managed,pci" {
+		t.Errorf("incorrect tags. Expected \"core,edge,managed,pci\", got \"%s\"", tags)
+	}
+
+	tests := []struct {
+		filter   []string
+		expected string
+	}{
+		{[]string{"tag:pci"}, "sw1,sw3"},
+		{[]string{"tag:managed"}, "sw1,sw2,sw3,sw4,sw5"},
+		{[]string{"tag:core & !tag:pci"}, "sw2"},
+		{[]string{"tag:edge", "!hq"}, "sw4"},
+		{[]string{"tag:p*"}, "sw1,sw3"},
+		{[]string{"where platform=junos"}, "sw1,sw3"},
+		{[]string{"where platform=junos and site=hq and tags!=*edge*"}, "sw3"},
+		{[]string{"where site=\"branch 1\""}, "sw4"},
+		{[]string{"where site=branch*"}, "sw4"},
+		{[]string{"where site="}, "sw5"},
+		{[]string{"where platform!=junos | tag:pci"}, "sw1,sw2,sw3,sw4,sw5"},
+		{[]string{"!where platform=ios"}, "sw1,sw3"},
+	}
+
+	for _, test := range tests {
+		filtered, err := Filter(list, test.filter)
+		if err != nil {
+			t.Errorf("filter %q returned error: %s", test.filter, err)
+			continue
+		}
+		if names := selectedNames(filtered); names != test.expected {
+			t.Errorf("incorrect devices for filter %q. Expected \"%s\", got \"%s\"", test.filter, test.expected, names)
+		}
+	}
+
+	for _, filter := range []string{"tag:", "where ", "where platform", "where site=[a", "tag:[a"} {
+		if _, err := Filter(list, []string{filter}); err == nil {
+			t.Errorf("filter %q should return an error", filter)
+		}
+	}
+}
+
+func TestDisabledDevices(t *testing.T) {
+	list, err := ParseString(`
+[core]
+router1 disabled=true disabled_reason="RMA case 1234"
+router2
+router3 disabled=yes
+router4 disabled=maybe
+
+[access] disabled=true
+sw1
+sw2 disabled=false
+sw3 disabled=off
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	filtered, err := Filter(list, []string{"core", "access"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names := selectedNames(filtered); names != "router2,sw2,sw3" {
+		t.Errorf("incorrect devices. Expected \"router2,sw2,sw3\", got \"%s\"", names)
+	}
+	// A value that isn't understood must never leave a device enabled
+	if names := deviceListNames(filtered.Disabled()); names != "router1,router3,router4,sw1" {
+		t.Errorf("incorrect disabled devices. Expected \"router1,router3,router4,sw1\", got \"%s\"", names)
+	}
+	if reason := filtered.Disabled()[0].DisabledReason(); reason != "RMA case 1234" {
+		t.Errorf("incorrect disabled reason. Expected \"RMA case 1234\", got \"%s\"", reason)
+	}
+
+	// Disabled devices outside the limit aren't reported
+	limited, err := Limit(filtered, []string{"core"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names := selectedNames(limited); names != "router2" {
+		t.Errorf("incorrect limited devices. Expected \"router2\", got \"%s\"", names)
+	}
+	if names := deviceListNames(limited.Disabled()); names != "router1,router3,router4" {
+		t.Errorf("incorrect limited disabled devices. Expected \"router1,router3,router4\", got \"%s\"", names)
+	}
+}
+
+func deviceListNames(list []*Device) string {
+	names := make([]string, len(list))
+	for i, device := range list {
+		names[i] = device.Name
+	}
+	return strings.Join(names, ",")
+}
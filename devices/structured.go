@@ -0,0 +1,453 @@
+package devices
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// formatHeaderRegex matches a "# format: yaml" header on the first line of an inventory file
+var formatHeaderRegex = regexp.MustCompile(`^#\s*format\s*[=:]\s*(\w+)\s*$`)
+
+// inventoryFormat returns the format of an inventory file from its header or extension
+func inventoryFormat(filename string, data []byte) string {
+	firstLine := data
+	if i := bytes.IndexByte(data, '\n'); i >= 0 {
+		firstLine = data[:i]
+	}
+	if m := formatHeaderRegex.FindSubmatch(bytes.TrimSpace(firstLine)); m != nil {
+		return strings.ToLower(string(m[1]))
+	}
+
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".yml", ".yaml":
+		return "yaml"
+	case ".json":
+		return "json"
+	}
+	return "ini"
+}
+
+// parseStructured reads a YAML or JSON inventory. The document is a mapping with the keys
+// "global", "groups", "include", and "plugins". Child groups are not linked. The stack is
+// the files that included this one and is used to find include cycles.
+func parseStructured(data []byte, format, filename string, stack []string) (*DeviceList, error) {
+	var doc interface{}
+	var err error
+	if format == "yaml" {
+		doc, err = parseYAML(bytes.NewReader(data))
+	} else {
+		// JSON doesn't allow comments so a format header is removed
+		if bytes.HasPrefix(bytes.TrimSpace(data), []byte("#")) {
+			if i := bytes.IndexByte(data, '\n'); i >= 0 {
+				data = data[i+1:]
+			}
+		}
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.UseNumber()
+		err = decoder.Decode(&doc)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Error in inventory file %s: %s\n", filename, err.Error())
+	}
+
+	devices, err := buildStructured(doc, filename, stack)
+	if err != nil {
+		return nil, fmt.Errorf("Error in inventory file %s: %s\n", filename, err.Error())
+	}
+	return devices, nil
+}
+
+func buildStructured(doc interface{}, filename string, stack []string) (*DeviceList, error) {
+	devices := &DeviceList{
+		Groups:  make(map[string]*Group),
+		Devices: make(map[string]*Device),
+	}
+	if doc == nil {
+		return devices, nil
+	}
+
+	top, ok := doc.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("Top level of inventory must be a mapping")
+	}
+	for key := range top {
+		if key != "global" && key != "groups" && key != "include" && key != "plugins" {
+			return nil, fmt.Errorf("Unknown key \"%s\"", key)
+		}
+	}
+
+	if top["global"] != nil {
+		settings, err := structuredSettings(top["global"], "global")
+		if err != nil {
+			return nil, err
+		}
+		devices.Groups["global"] = &Group{
+			Name:     "global",
+			settings: settings,
+			list:     devices,
+		}
+	}
+
+	if top["groups"] != nil {
+		groups, ok := top["groups"].(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("Groups must be a mapping of group names")
+		}
+		if err := buildStructuredGroups(devices, groups); err != nil {
+			return nil, err
+		}
+	}
+
+	includes, err := structuredStringList(top["include"], "include")
+	if err != nil {
+		return nil, err
+	}
+	if filename != "" {
+		stack = append(stack, filename)
+	}
+	for _, include := range includes {
+		pattern := includePath(filename, include)
+		files := []string{pattern}
+		if strings.ContainsAny(pattern, "*?[") {
+			files, err = filepath.Glob(pattern)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid include pattern %s", pattern)
+			}
+			if len(files) == 0 {
+				devices.addIssue(LintWarning, "Include pattern %s didn't match any files", pattern)
+			}
+		}
+
+		for _, incFilename := range files {
+			for i, name := range stack {
+				if name == incFilename {
+					cycle := append(append([]string{}, stack[i:]...), incFilename)
+					return nil, fmt.Errorf("Include cycle found: %s", strings.Join(cycle, " -> "))
+				}
+			}
+			included, err := readInventoryFile(incFilename, stack)
+			if err != nil {
+				return nil, err
+			}
+			if err := mergeDeviceList(devices, included); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if top["plugins"] != nil {
+		plugins, ok := top["plugins"].([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("Plugins must be a list")
+		}
+		for _, p := range plugins {
+			plugin, err := structuredPlugin(p, filename)
+			if err != nil {
+				return nil, err
+			}
+			loaded, err := plugin.load()
+			if err != nil {
+				return nil, err
+			}
+			if err := mergeDeviceList(devices, loaded); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return devices, nil
+}
+
+// structuredPlugin converts a plugin mapping with the keys script, args, env, and cache_ttl.
+// The script is relative to the directory of filename.
+func structuredPlugin(value interface{}, filename string) (*inventoryPlugin, error) {
+	def, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("Each plugin must be a mapping")
+	}
+
+	plugin := &inventoryPlugin{}
+	for key, v := range def {
+		var err error
+		switch key {
+		case "script":
+			script, ok := scalarString(v)
+			if !ok || script == "" {
+				return nil, fmt.Errorf("Plugin script must be a file name")
+			}
+			plugin.script = includePath(filename, script)
+		case "args":
+			plugin.args, err = structuredStringList(v, "plugin args")
+		case "env":
+			var env map[string]string
+			env, err = structuredSettings(v, "plugin env")
+			for name, value := range env {
+				plugin.env = append(plugin.env, name+"="+value)
+			}
+		case "cache_ttl":
+			ttl, _ := scalarString(v)
+			plugin.cacheTTL, err = parseTTL(ttl)
+		default:
+			err = fmt.Errorf("Unknown plugin key \"%s\"", key)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if plugin.script == "" {
+		return nil, fmt.Errorf("No script given for plugin")
+	}
+	return plugin, nil
+}
+
+// buildStructuredGroups adds groups to devices. Groups are added in alphabetical order.
+func buildStructuredGroups(devices *DeviceList, groups map[string]interface{}) error {
+	names := make([]string, 0, len(groups))
+	for name := range groups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	// Create all groups first so device names can be checked against them
+	for _, name := range names {
+		if name == "global" {
+			return fmt.Errorf("Global settings must be given with the global key")
+		}
+		devices.Groups[name] = &Group{
+			Name:     name,
+			settings: make(map[string]string),
+			list:     devices,
+		}
+	}
+
+	for _, name := range names {
+		if groups[name] == nil {
+			continue
+		}
+		def, ok := groups[name].(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("Group %s must be a mapping", name)
+		}
+		group := devices.Groups[name]
+
+		for key, value := range def {
+			var err error
+			switch key {
+			case "settings":
+				group.settings, err = structuredSettings(value, "group "+name)
+			case "children":
+				group.Children, err = structuredStringList(value, "children of group "+name)
+			case "devices":
+				err = buildStructuredDevices(devices, group, value)
+			default:
+				err = fmt.Errorf("Unknown key \"%s\" in group %s", key, name)
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// buildStructuredDevices adds the devices of a group. Devices may be a list of names or
+// a mapping of names to settings.
+func buildStructuredDevices(devices *DeviceList, group *Group, value interface{}) error {
+	deviceSettings := make(map[string]map[string]string)
+	var names []string
+
+	switch v := value.(type) {
+	case nil:
+		return nil
+	case []interface{}:
+		var err error
+		names, err = structuredStringList(v, "devices of group "+group.Name)
+		if err != nil {
+			return err
+		}
+	case map[string]interface{}:
+		for name, settings := range v {
+			names = append(names, name)
+			if settings == nil {
+				continue
+			}
+			s, err := structuredSettings(settings, "device "+name)
+			if err != nil {
+				return err
+			}
+			deviceSettings[name] = s
+		}
+		sort.Strings(names)
+	default:
+		return fmt.Errorf("Devices of group %s must be a list or mapping", group.Name)
+	}
+
+	for _, name := range names {
+		if _, exists := devices.Groups[name]; exists {
+			return fmt.Errorf("Can't define a device with the same name as a group: %s", name)
+		}
+
+		device, exists := devices.Devices[name]
+		if !exists {
+			device = &Device{
+				Name:     name,
+				settings: make(map[string]string),
+				list:     devices,
+			}
+			devices.Devices[name] = device
+		}
+		if err := mergeSettings(device, deviceSettings[name]); err != nil {
+			return err
+		}
+		device.Groups = append(device.Groups, group.Name)
+		group.Devices = append(group.Devices, device)
+	}
+	return nil
+}
+
+// mergeSettings adds settings to a device declared in several groups. It's an error
+// to give a setting different values.
+func mergeSettings(device *Device, settings map[string]string) error {
+	for key, value := range settings {
+		if current, exists := device.settings[key]; exists && current != value {
+			return fmt.Errorf("Device %s has conflicting values for setting %s", device.Name, key)
+		}
+		device.settings[key] = value
+	}
+	return nil
+}
+
+// structuredSettings converts a mapping to settings. Nested keys are joined with a period
+// and lists are joined with a comma the same as variable files.
+func structuredSettings(value interface{}, name string) (map[string]string, error) {
+	settings := make(map[string]string)
+	if value == nil {
+		return settings, nil
+	}
+	if _, ok := value.(map[string]interface{}); !ok {
+		return nil, fmt.Errorf("Settings of %s must be a mapping", name)
+	}
+	if err := flattenVars(settings, "", value); err != nil {
+		return nil, err
+	}
+	return settings, nil
+}
+
+// structuredStringList converts a list of scalars or a single scalar to a list of strings
+func structuredStringList(value interface{}, name string) ([]string, error) {
+	if value == nil {
+		return nil, nil
+	}
+	if s, ok := scalarString(value); ok {
+		return []string{s}, nil
+	}
+
+	items, ok := value.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("The %s must be a list", name)
+	}
+	list := make([]string, len(items))
+	for i, item := range items {
+		s, ok := scalarString(item)
+		if !ok || s == "" {
+			return nil, fmt.Errorf("The %s must only contain names", name)
+		}
+		list[i] = s
+	}
+	return list, nil
+}
+
+// mergeDeviceList adds the groups and devices of src to dst. Settings already in dst
+// take precedence over those from src.
+func mergeDeviceList(dst, src *DeviceList) error {
+	dst.issues = append(dst.issues, src.issues...)
+
+	groupNames := make([]string, 0, len(src.Groups))
+	for name := range src.Groups {
+		groupNames = append(groupNames, name)
+	}
+	sort.Strings(groupNames)
+
+	for _, name := range groupNames {
+		if _, exists := dst.Devices[name]; exists {
+			return fmt.Errorf("Can't define a group with the same name as a device: %s", name)
+		}
+		group := src.Groups[name]
+		existing, exists := dst.Groups[name]
+		if !exists {
+			dst.Groups[name] = &Group{
+				Name:     name,
+				Children: group.Children,
+				settings: group.settings,
+				literal:  group.literal,
+				list:     dst,
+			}
+			continue
+		}
+		for key, value := range group.settings {
+			if _, set := existing.settings[key]; !set {
+				existing.settings[key] = value
+				existing.setLiteral(key, group.literal[key])
+			}
+		}
+		existing.Children = append(existing.Children, group.Children...)
+	}
+
+	for name, device := range src.Devices {
+		if _, exists := dst.Groups[name]; exists {
+			return fmt.Errorf("Can't define a device with the same name as a group: %s", name)
+		}
+		existing, exists := dst.Devices[name]
+		if !exists {
+			device.list = dst
+			dst.Devices[name] = device
+			continue
+		}
+		for key, value := range device.settings {
+			if _, set := existing.settings[key]; !set {
+				existing.settings[key] = value
+				existing.setLiteral(key, device.literal[key])
+			}
+		}
+		for _, group := range device.Groups {
+			if !containsString(existing.Groups, group) {
+				existing.Groups = append(existing.Groups, group)
+			}
+		}
+	}
+
+	// Add the devices of each group using the device in dst
+	for _, name := range groupNames {
+		group := dst.Groups[name]
+		for _, device := range src.Groups[name].Devices {
+			device = dst.Devices[device.Name]
+			if !containsDevice(group.Devices, device) {
+				group.Devices = append(group.Devices, device)
+			}
+		}
+	}
+	return nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func containsDevice(list []*Device, d *Device) bool {
+	for _, item := range list {
+		if item == d {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,148 @@
+package devices
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var testStructuredFiles = map[string]string{
+	"hosts.yml": `
+global:
+  remote_user: peter
+  remote_password: cottentail
+
+groups:
+  boston co-location:
+    devices:
+      server1:
+      server2:
+        address: 10.0.0.2
+      server3: {remote_user: peter1}
+      server4:
+        address: 10.0.0.4
+        protocol: telnet
+  san fran location:
+    settings:
+      cisco_enable: orange_cone
+    devices: [server1b, server2b]
+  web app:
+    devices:
+      - server1
+      - server2b
+`,
+	"hosts.json": `{
+	"global": {"remote_user": "peter", "remote_password": "cottentail"},
+	"groups": {
+		"boston co-location": {
+			"devices": {
+				"server1": null,
+				"server2": {"address": "10.0.0.2"},
+				"server3": {"remote_user": "peter1"},
+				"server4": {"address": "10.0.0.4", "protocol": "telnet"}
+			}
+		},
+		"san fran location": {
+			"settings": {"cisco_enable": "orange_cone"},
+			"devices": ["server1b", "server2b"]
+		},
+		"web app": {"devices": ["server1", "server2b"]}
+	}
+}`,
+	"hosts": `# format: json
+{
+	"global": {"remote_user": "peter", "remote_password": "cottentail"},
+	"groups": {
+		"san fran location": {"settings": {"cisco_enable": "orange_cone"}},
+		"web app": {"devices": ["server1", "server2b"]}
+	},
+	"include": "included.conf"
+}`,
+	"included.conf": `
+[boston co-location]
+server1
+server2 address=10.0.0.2
+server3 remote_user=peter1
+server4 address=10.0.0.4 protocol=telnet
+
+[san fran location] cisco_enable=ignored
+server1b
+server2b
+`,
+}
+
+func TestStructuredInventory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "inca-inventory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for name, contents := range testStructuredFiles {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Includes are relative to the including file, not the working directory
+	cwd, _ := os.Getwd()
+	defer os.Chdir(cwd)
+	os.Chdir(os.TempDir())
+
+	for _, name := range []string{"hosts.yml", "hosts.json", "hosts"} {
+		list, err := ParseFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Errorf("%s: %s", name, err)
+			continue
+		}
+
+		if len(list.Groups) != 4 {
+			t.Errorf("%s: incorrect number of groups. Expected 4, got %d", name, len(list.Groups))
+		}
+
+		if len(list.Devices) != 6 {
+			t.Errorf("%s: incorrect number of devices. Expected 6, got %d", name, len(list.Devices))
+		}
+
+		if list.Devices["server3"].GetSetting("remote_user") != "peter1" {
+			t.Errorf("%s: incorrect device setting remote_user. Expected \"peter1\", got \"%s\"", name, list.Devices["server3"].GetSetting("remote_user"))
+		}
+
+		if list.Devices["server4"].GetSetting("protocol") != "telnet" {
+			t.Errorf("%s: incorrect device protocol. Expected \"telnet\", got \"%s\"", name, list.Devices["server4"].GetSetting("protocol"))
+		}
+
+		if list.Devices["server2b"].GetSetting("cisco_enable") != "orange_cone" {
+			t.Errorf("%s: incorrect device setting cisco_enable. Expected \"orange_cone\", got \"%s\"", name, list.Devices["server2b"].GetSetting("cisco_enable"))
+		}
+
+		if list.Devices["server1"].GetSetting("remote_password") != "cottentail" {
+			t.Errorf("%s: incorrect device setting remote_password. Expected \"cottentail\", got \"%s\"", name, list.Devices["server1"].GetSetting("remote_password"))
+		}
+
+		if len(list.Devices["server1"].Groups) != 2 {
+			t.Errorf("%s: incorrect number of group memberships. Expected 2, got %d", name, len(list.Devices["server1"].Groups))
+		}
+
+		if len(list.Groups["web app"].Devices) != 2 {
+			t.Errorf("%s: incorrect number of group devices. Expected 2, got %d", name, len(list.Groups["web app"].Devices))
+		}
+	}
+}
+
+func TestStructuredInventoryErrors(t *testing.T) {
+	docs := []string{
+		`[1, 2]`,
+		`{"groups": {"global": {}}}`,
+		`{"groups": {"a": {"devices": ["a"]}}}`,
+		`{"groups": {"a": {"hosts": []}}}`,
+		`{"groups": {"a": {"devices": {"d1": {"address": "1"}}}, "b": {"devices": {"d1": {"address": "2"}}}}}`,
+		`{"inventory": {}}`,
+	}
+	for _, doc := range docs {
+		if _, err := parseStructured([]byte(doc), "json", "", nil); err == nil {
+			t.Errorf("inventory should return an error: %s", doc)
+		}
+	}
+}
@@ -0,0 +1,228 @@
+package devices
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// varsFileExtensions are the extensions searched for group and host variable files
+var varsFileExtensions = []string{".yml", ".yaml", ".json"}
+
+// ParseVarsFile reads a file of variables. The format is determined by the file extension.
+// Files ending in .yml or .yaml are read as YAML, .json as JSON, and anything else as INI.
+// Nested keys are joined with a period and lists are joined with a comma.
+func ParseVarsFile(filename string) (map[string]string, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var vars map[string]string
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".yml", ".yaml":
+		vars, err = parseYAMLVars(file)
+	case ".json":
+		vars, err = parseJSONVars(file)
+	default:
+		vars, err = parseINIVars(file)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Error in variable file %s: %s", filename, err.Error())
+	}
+	return vars, nil
+}
+
+func parseYAMLVars(r io.Reader) (map[string]string, error) {
+	data, err := parseYAML(r)
+	if err != nil {
+		return nil, err
+	}
+	vars := make(map[string]string)
+	if data == nil {
+		return vars, nil
+	}
+	if err := flattenVars(vars, "", data); err != nil {
+		return nil, err
+	}
+	return vars, nil
+}
+
+func parseJSONVars(r io.Reader) (map[string]string, error) {
+	var data interface{}
+	decoder := json.NewDecoder(r)
+	decoder.UseNumber()
+	if err := decoder.Decode(&data); err != nil {
+		return nil, err
+	}
+	vars := make(map[string]string)
+	if err := flattenVars(vars, "", data); err != nil {
+		return nil, err
+	}
+	return vars, nil
+}
+
+// parseINIVars reads "key = value" lines. Keys in a [section] are prefixed with the section name.
+func parseINIVars(r io.Reader) (map[string]string, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Split(bufio.ScanLines)
+	vars := make(map[string]string)
+	section := ""
+	lineNum := 0
+
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		lineNum++
+
+		if len(line) == 0 || line[0] == '#' || line[0] == ';' {
+			continue
+		}
+
+		if line[0] == '[' {
+			if line[len(line)-1] != ']' {
+				return nil, fmt.Errorf("Error defining section on line %d", lineNum)
+			}
+			section = string(bytes.TrimSpace(line[1 : len(line)-1]))
+			continue
+		}
+
+		sep := bytes.IndexAny(line, "=:")
+		if sep < 1 {
+			return nil, fmt.Errorf("Expected \"key = value\" on line %d", lineNum)
+		}
+		key := string(bytes.TrimSpace(line[:sep]))
+		if section != "" {
+			key = section + "." + key
+		}
+		value := string(bytes.TrimSpace(line[sep+1:]))
+		if len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"' {
+			value = value[1 : len(value)-1]
+		}
+		vars[key] = value
+	}
+	return vars, scanner.Err()
+}
+
+// flattenVars flattens a decoded YAML or JSON document into a map of strings.
+func flattenVars(vars map[string]string, prefix string, data interface{}) error {
+	switch v := data.(type) {
+	case map[string]interface{}:
+		for key, value := range v {
+			if prefix != "" {
+				key = prefix + "." + key
+			}
+			if err := flattenVars(vars, key, value); err != nil {
+				return err
+			}
+		}
+		return nil
+	case []interface{}:
+		if prefix == "" {
+			return fmt.Errorf("Top level of variable file must be a mapping")
+		}
+		items := make([]string, len(v))
+		for i, item := range v {
+			s, ok := scalarString(item)
+			if !ok {
+				return fmt.Errorf("Lists can only contain scalar values, key \"%s\"", prefix)
+			}
+			items[i] = s
+		}
+		vars[prefix] = strings.Join(items, ",")
+		return nil
+	}
+
+	if prefix == "" {
+		return fmt.Errorf("Top level of variable file must be a mapping")
+	}
+	s, _ := scalarString(data)
+	vars[prefix] = s
+	return nil
+}
+
+// scalarString converts a decoded scalar value to a string
+func scalarString(v interface{}) (string, bool) {
+	switch s := v.(type) {
+	case nil:
+		return "", true
+	case string:
+		return s, true
+	case bool:
+		if s {
+			return "true", true
+		}
+		return "false", true
+	case json.Number:
+		return s.String(), true
+	case float64:
+		return fmt.Sprint(s), true
+	}
+	return "", false
+}
+
+// findVarsFile looks for a variable file in dir named name with one of the supported extensions.
+// An empty string is returned if no file exists.
+func findVarsFile(dir, name string) string {
+	for _, ext := range varsFileExtensions {
+		filename := filepath.Join(dir, name+ext)
+		if _, err := os.Stat(filename); err == nil {
+			return filename
+		}
+	}
+	return ""
+}
+
+// loadVarsDirectories merges the group_vars and host_vars files next to the inventory file
+// into the group and device settings. Settings from these files override inline settings.
+func loadVarsDirectories(devices *DeviceList, dir string) error {
+	// Global variables may be given without a global group in the inventory
+	if _, exists := devices.Groups["global"]; !exists && findVarsFile(filepath.Join(dir, "group_vars"), "global") != "" {
+		devices.Groups["global"] = &Group{
+			Name:     "global",
+			settings: make(map[string]string),
+			list:     devices,
+		}
+	}
+
+	groupNames := make([]string, 0, len(devices.Groups))
+	for name := range devices.Groups {
+		groupNames = append(groupNames, name)
+	}
+	sort.Strings(groupNames)
+
+	for _, name := range groupNames {
+		filename := findVarsFile(filepath.Join(dir, "group_vars"), name)
+		if filename == "" {
+			continue
+		}
+		vars, err := ParseVarsFile(filename)
+		if err != nil {
+			return err
+		}
+		for key, value := range vars {
+			devices.Groups[name].settings[key] = value
+		}
+	}
+
+	for name, device := range devices.Devices {
+		filename := findVarsFile(filepath.Join(dir, "host_vars"), name)
+		if filename == "" {
+			continue
+		}
+		vars, err := ParseVarsFile(filename)
+		if err != nil {
+			return err
+		}
+		for key, value := range vars {
+			device.settings[key] = value
+		}
+	}
+	return nil
+}
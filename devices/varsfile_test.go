@@ -0,0 +1,184 @@
+package devices
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var testVarsFiles = map[string]string{
+	"vars.yml": `
+# Comment
+mgmt_vlan: 30
+site: hq
+snmp_location: "Bldg 1" # Trailing comment
+url: http://example.com:8080/path
+ntp:
+  - 10.0.0.1
+  - 10.0.0.2
+dns: [10.0.0.3, 10.0.0.4]
+syslog:
+  host: "fe80::1"
+  level: info
+banner: |
+  Authorized
+  access only
+`,
+	"vars.json": `{
+	"mgmt_vlan": 30,
+	"site": "hq",
+	"snmp_location": "Bldg 1",
+	"url": "http://example.com:8080/path",
+	"ntp": ["10.0.0.1", "10.0.0.2"],
+	"dns": ["10.0.0.3", "10.0.0.4"],
+	"syslog": {"host": "fe80::1", "level": "info"},
+	"banner": "Authorized\naccess only\n"
+}`,
+	"vars.ini": `
+; Comment
+mgmt_vlan = 30
+site = hq
+snmp_location = "Bldg 1"
+url = http://example.com:8080/path
+ntp = 10.0.0.1,10.0.0.2
+dns = 10.0.0.3,10.0.0.4
+banner = Authorized
+
+[syslog]
+host = fe80::1
+level = info
+`,
+}
+
+func TestParseVarsFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "inca-vars")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	expected := map[string]string{
+		"mgmt_vlan":     "30",
+		"site":          "hq",
+		"snmp_location": "Bldg 1",
+		"url":           "http://example.com:8080/path",
+		"ntp":           "10.0.0.1,10.0.0.2",
+		"dns":           "10.0.0.3,10.0.0.4",
+		"syslog.host":   "fe80::1",
+		"syslog.level":  "info",
+		"banner":        "Authorized\naccess only\n",
+	}
+
+	for name, contents := range testVarsFiles {
+		filename := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(filename, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		vars, err := ParseVarsFile(filename)
+		if err != nil {
+			t.Errorf("%s: %s", name, err.Error())
+			continue
+		}
+
+		// INI can't have multiline values
+		want := expected
+		if name == "vars.ini" {
+			want = make(map[string]string)
+			for k, v := range expected {
+				want[k] = v
+			}
+			want["banner"] = "Authorized"
+		}
+		if !reflect.DeepEqual(vars, want) {
+			t.Errorf("%s: variables not equal:\n%#v\n\n%#v\n\n", name, want, vars)
+		}
+	}
+}
+
+func TestParseYAMLVars(t *testing.T) {
+	// Anchors, aliases, and merge keys are common in Ansible variable files
+	vars, err := parseYAMLVars(strings.NewReader(`
+defaults: &defaults
+  remote_user: netops
+  port: 22
+core: &core
+  <<: *defaults
+  port: 2222
+ntp: &ntp [10.0.0.1, 10.0.0.2]
+backup_ntp: *ntp
+enabled: true
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{
+		"defaults.remote_user": "netops",
+		"defaults.port":        "22",
+		"core.remote_user":     "netops",
+		"core.port":            "2222",
+		"ntp":                  "10.0.0.1,10.0.0.2",
+		"backup_ntp":           "10.0.0.1,10.0.0.2",
+		"enabled":              "true",
+	}
+	if !reflect.DeepEqual(vars, expected) {
+		t.Errorf("variables not equal:\n%#v\n\n%#v\n\n", expected, vars)
+	}
+
+	for name, contents := range map[string]string{
+		"multiple documents": "site: hq\n---\nsite: branch\n",
+		"invalid":            "site: [hq\n",
+	} {
+		if _, err := parseYAMLVars(strings.NewReader(contents)); err == nil {
+			t.Errorf("%s should return an error", name)
+		}
+	}
+}
+
+func TestVarsDirectories(t *testing.T) {
+	dir, err := ioutil.TempDir("", "inca-vars")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"devices.conf":                       testConfig,
+		"group_vars/san fran location.yml":   "site: sfo\ncisco_enable: blue_cone\n",
+		"group_vars/global.json":             `{"mgmt_vlan": 10}`,
+		"host_vars/server2b.yaml":            "mgmt_vlan: 30\n",
+		"host_vars/not-in-the-inventory.yml": "site: nowhere\n",
+	}
+	for name, contents := range files {
+		filename := filepath.Join(dir, name)
+		os.MkdirAll(filepath.Dir(filename), 0755)
+		if err := ioutil.WriteFile(filename, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	list, err := ParseFile(filepath.Join(dir, "devices.conf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		device, setting, expected string
+	}{
+		{"server1", "mgmt_vlan", "10"},
+		{"server1", "site", ""},
+		{"server1b", "site", "sfo"},
+		{"server1b", "cisco_enable", "blue_cone"},
+		{"server1b", "mgmt_vlan", "10"},
+		{"server2b", "mgmt_vlan", "30"},
+	}
+	for _, test := range tests {
+		if list.Devices[test.device].GetSetting(test.setting) != test.expected {
+			t.Errorf("incorrect device setting %s on %s. Expected \"%s\", got \"%s\"",
+				test.setting, test.device, test.expected, list.Devices[test.device].GetSetting(test.setting))
+		}
+	}
+}
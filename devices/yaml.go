@@ -0,0 +1,80 @@
+package devices
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+
+	"gopkg.in/yaml.v2"
+)
+
+// parseYAML decodes a YAML document into the same types as a JSON document decoded with
+// UseNumber. Mappings are decoded as map[string]interface{}, sequences as []interface{},
+// numbers as json.Number, and null values as nil. Only one document is allowed.
+func parseYAML(r io.Reader) (interface{}, error) {
+	var docs []interface{}
+	decoder := yaml.NewDecoder(r)
+	for {
+		var doc interface{}
+		err := decoder.Decode(&doc)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		docs = append(docs, doc)
+	}
+	if len(docs) > 1 {
+		return nil, fmt.Errorf("Only one YAML document is allowed, found %d", len(docs))
+	}
+	if len(docs) == 0 {
+		return nil, nil
+	}
+	return convertYAML(docs[0])
+}
+
+// convertYAML converts the values decoded by the YAML library to the types of decoded JSON
+func convertYAML(value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			name, ok := scalarString(key)
+			if !ok || key == nil {
+				switch k := key.(type) {
+				case int, int64, uint64, float64:
+					name = fmt.Sprint(k)
+				default:
+					return nil, fmt.Errorf("Mapping keys must be scalars, not %v", key)
+				}
+			}
+			converted, err := convertYAML(item)
+			if err != nil {
+				return nil, err
+			}
+			m[name] = converted
+		}
+		return m, nil
+	case []interface{}:
+		list := make([]interface{}, len(v))
+		for i, item := range v {
+			converted, err := convertYAML(item)
+			if err != nil {
+				return nil, err
+			}
+			list[i] = converted
+		}
+		return list, nil
+	case int:
+		return json.Number(strconv.Itoa(v)), nil
+	case int64:
+		return json.Number(strconv.FormatInt(v, 10)), nil
+	case uint64:
+		return json.Number(strconv.FormatUint(v, 10)), nil
+	case float64:
+		return json.Number(strconv.FormatFloat(v, 'g', -1, 64)), nil
+	}
+	return value, nil
+}
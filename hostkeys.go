@@ -0,0 +1,81 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/lfkeitel/inca-tool/devices"
+	"github.com/lfkeitel/inca-tool/hostkeys"
+)
+
+var knownHostsFile string // flag
+
+// runHostKeysCommand runs the hostkeys subcommand given in args
+func runHostKeysCommand(args []string) error {
+	switch args[0] {
+	case "list":
+		entries, err := hostkeys.List(knownHostsFile, inventoryAliases()...)
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "DEVICE\tADDRESS\tTYPE\tFINGERPRINT")
+		for _, entry := range entries {
+			device := entry.Device
+			if entry.Hashed && device == "" {
+				device = "(hashed)"
+			}
+			keyType := entry.KeyType
+			if entry.Marker != "" {
+				keyType += " (" + entry.Marker + ")"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", device, entry.Address, keyType, entry.Fingerprint())
+		}
+		return w.Flush()
+
+	case "forget":
+		if len(args) != 2 {
+			return errors.New("Usage: hostkeys forget <device>")
+		}
+		removed, err := hostkeys.Forget(knownHostsFile, args[1], inventoryAliases()...)
+		if err != nil {
+			return err
+		}
+		if removed == 0 {
+			return fmt.Errorf("No host keys are known for %s", args[1])
+		}
+		fmt.Printf("Removed %d host key(s) for %s\n", removed, args[1])
+		return nil
+	}
+
+	return fmt.Errorf("Unknown hostkeys command %s", args[0])
+}
+
+// inventoryAliases returns the aliases of every device and jump host in the inventory so
+// entries with hashed names can be matched. If the inventory can't be read, no aliases are returned.
+func inventoryAliases() []string {
+	filename := inventoryFile
+	if filename == "" {
+		filename = defaultInventoryFile
+	}
+	list, err := devices.ParseFile(filename)
+	if err != nil {
+		return nil
+	}
+
+	var aliases []string
+	for name, device := range list.Devices {
+		address := device.GetSetting("address")
+		if address == "" {
+			address = name
+		}
+		aliases = append(aliases, hostkeys.Alias(name, address))
+		if proxy := device.GetSetting("proxy_jump"); proxy != "" {
+			aliases = append(aliases, strings.Split(proxy, ":")[0])
+		}
+	}
+	return aliases
+}
@@ -0,0 +1,171 @@
+package hostkeys
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// Entry is a host key recorded in the known hosts store. If Hashed is true, the entry was
+// recorded with a hashed name and Device and Address are only known if a matching alias was given.
+// Marker is the marker of the line without the @, such as revoked or cert-authority, or empty
+// for a normal key.
+type Entry struct {
+	Device  string
+	Address string
+	KeyType string
+	Key     string
+	Hashed  bool
+	Marker  string
+}
+
+// splitLine returns the marker and the remaining fields of a known hosts line. The marker is
+// empty if the line doesn't start with one such as @revoked.
+func splitLine(line string) (string, []string) {
+	fields := strings.Fields(line)
+	if len(fields) > 0 && strings.HasPrefix(fields[0], "@") {
+		return fields[0][1:], fields[1:]
+	}
+	return "", fields
+}
+
+// Alias returns the name a device's host key is recorded under. Keys are recorded
+// per device name and address so a device moving to a new address is verified again.
+func Alias(device, address string) string {
+	return device + "@" + address
+}
+
+// splitAlias splits an alias into the device name and address
+func splitAlias(alias string) (string, string) {
+	i := strings.LastIndex(alias, "@")
+	if i < 0 {
+		return alias, ""
+	}
+	return alias[:i], alias[i+1:]
+}
+
+// hashMatches returns if a hashed known hosts name in the form |1|salt|hash is the hash of alias
+func hashMatches(name, alias string) bool {
+	parts := strings.Split(name, "|")
+	if len(parts) != 4 || parts[0] != "" || parts[1] != "1" {
+		return false
+	}
+	salt, err := base64.StdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return false
+	}
+	hash, err := base64.StdEncoding.DecodeString(parts[3])
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha1.New, salt)
+	mac.Write([]byte(alias))
+	return hmac.Equal(mac.Sum(nil), hash)
+}
+
+// entryAlias returns the alias an entry name was recorded under. Hashed names are matched
+// against aliases. False is returned for a hashed name that doesn't match any alias.
+func entryAlias(name string, aliases []string) (string, bool, bool) {
+	if !strings.HasPrefix(name, "|") {
+		return name, false, true
+	}
+	for _, alias := range aliases {
+		if hashMatches(name, alias) {
+			return alias, true, true
+		}
+	}
+	return "", true, false
+}
+
+// Fingerprint returns the SHA256 fingerprint of the key in the same format as ssh-keygen
+func (e *Entry) Fingerprint() string {
+	key, err := base64.StdEncoding.DecodeString(e.Key)
+	if err != nil {
+		return "invalid key"
+	}
+	sum := sha256.Sum256(key)
+	return "SHA256:" + base64.RawStdEncoding.EncodeToString(sum[:])
+}
+
+// List returns all entries in the known hosts file filename. A file that
+// doesn't exist has no entries. Entries with hashed names, such as when ssh is configured
+// with HashKnownHosts, are matched against aliases to find their device and address.
+// A marker such as @revoked is given in the entry's Marker.
+func List(filename string, aliases ...string) ([]*Entry, error) {
+	file, err := os.Open(filename)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var entries []*Entry
+	scanner := bufio.NewScanner(file)
+	scanner.Split(bufio.ScanLines)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		marker, fields := splitLine(line)
+		if len(fields) < 3 {
+			continue
+		}
+		entry := &Entry{KeyType: fields[1], Key: fields[2], Marker: marker}
+		alias, hashed, known := entryAlias(fields[0], aliases)
+		entry.Hashed = hashed
+		if known {
+			entry.Device, entry.Address = splitAlias(alias)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
+
+// Forget removes all entries for device from the known hosts file filename. Entries with
+// hashed names are removed if they match one of the device's aliases. Entries with a marker
+// such as @revoked are kept since they aren't keys that were trusted. It returns the
+// number of entries removed.
+func Forget(filename, device string, aliases ...string) (int, error) {
+	data, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	buf := &bytes.Buffer{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Split(bufio.ScanLines)
+	for scanner.Scan() {
+		line := scanner.Text()
+		marker, fields := splitLine(line)
+		if len(fields) > 0 && marker == "" {
+			alias, _, known := entryAlias(fields[0], aliases)
+			if name, _ := splitAlias(alias); known && name == device {
+				removed++
+				continue
+			}
+		}
+		buf.WriteString(line)
+		buf.WriteString("\n")
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+
+	if removed == 0 {
+		return 0, nil
+	}
+	return removed, ioutil.WriteFile(filename, buf.Bytes(), 0600)
+}
@@ -0,0 +1,164 @@
+package hostkeys
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var testKnownHosts = `core1@10.0.0.1 ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIBxv4d3GLrmWs0Y9gYD8o1Mw3qUbhdmhpBrOu0DLo0Ry
+core1@10.0.1.1 ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIFrM5IOS8n3Yt7xIX8kNnnSEV6m0d9QZpv1xH6Z3q7mE
+edge1@10.0.0.2 ecdsa-sha2-nistp256 AAAAE2VjZHNhLXNoYTItbmlzdHAyNTYAAAAIbmlzdHAyNTY=
+`
+
+func TestListAndForget(t *testing.T) {
+	dir, err := ioutil.TempDir("", "inca-hostkeys")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "known_hosts")
+
+	if entries, err := List(filename); err != nil || len(entries) != 0 {
+		t.Errorf("missing file should have no entries, got %d %v", len(entries), err)
+	}
+
+	if err := ioutil.WriteFile(filename, []byte(testKnownHosts), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := List(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("incorrect number of entries. Expected 3, got %d", len(entries))
+	}
+	if entries[2].Device != "edge1" || entries[2].Address != "10.0.0.2" || entries[2].KeyType != "ecdsa-sha2-nistp256" {
+		t.Errorf("incorrect entry: %#v", entries[2])
+	}
+	if entries[0].Fingerprint() == entries[1].Fingerprint() {
+		t.Error("different keys have the same fingerprint")
+	}
+
+	removed, err := Forget(filename, "core1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed != 2 {
+		t.Errorf("incorrect number of removed entries. Expected 2, got %d", removed)
+	}
+
+	entries, _ = List(filename)
+	if len(entries) != 1 || entries[0].Device != "edge1" {
+		t.Errorf("incorrect entries after forget: %#v", entries)
+	}
+}
+
+// hashName hashes alias the same way as ssh with HashKnownHosts
+func hashName(alias, salt string) string {
+	mac := hmac.New(sha1.New, []byte(salt))
+	mac.Write([]byte(alias))
+	return "|1|" + base64.StdEncoding.EncodeToString([]byte(salt)) + "|" + base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func TestHashedEntries(t *testing.T) {
+	dir, err := ioutil.TempDir("", "inca-hostkeys")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "known_hosts")
+
+	knownHosts := hashName("core1@10.0.0.1", "saltsaltsaltsaltsalt") + " ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIBxv4d3GLrmWs0Y9gYD8o1Mw3qUbhdmhpBrOu0DLo0Ry\n" +
+		hashName("bastion.example.com", "pepperpepperpepper12") + " ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIFrM5IOS8n3Yt7xIX8kNnnSEV6m0d9QZpv1xH6Z3q7mE\n" +
+		"edge1@10.0.0.2 ecdsa-sha2-nistp256 AAAAE2VjZHNhLXNoYTItbmlzdHAyNTYAAAAIbmlzdHAyNTY=\n"
+	if err := ioutil.WriteFile(filename, []byte(knownHosts), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	aliases := []string{"core1@10.0.0.1", "edge1@10.0.0.2"}
+	entries, err := List(filename, aliases...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("incorrect number of entries. Expected 3, got %d", len(entries))
+	}
+	if !entries[0].Hashed || entries[0].Device != "core1" || entries[0].Address != "10.0.0.1" {
+		t.Errorf("hashed entry should match its alias: %#v", entries[0])
+	}
+	if !entries[1].Hashed || entries[1].Device != "" {
+		t.Errorf("hashed entry without a matching alias should have no device: %#v", entries[1])
+	}
+	if entries[2].Hashed || entries[2].Device != "edge1" {
+		t.Errorf("incorrect plain entry: %#v", entries[2])
+	}
+
+	if removed, _ := Forget(filename, "core1"); removed != 0 {
+		t.Errorf("hashed entries can't be forgotten without aliases, removed %d", removed)
+	}
+	removed, err := Forget(filename, "core1", aliases...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed != 1 {
+		t.Errorf("incorrect number of removed entries. Expected 1, got %d", removed)
+	}
+
+	entries, _ = List(filename, aliases...)
+	if len(entries) != 2 || entries[0].Device != "" || entries[1].Device != "edge1" {
+		t.Errorf("incorrect entries after forget: %#v", entries)
+	}
+}
+
+func TestMarkerEntries(t *testing.T) {
+	dir, err := ioutil.TempDir("", "inca-hostkeys")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "known_hosts")
+
+	knownHosts := "@revoked core1@10.0.0.1 ssh-rsa AAAAB3NzaC1yc2EAAAADAQABAAABAQC7\n" +
+		"  # A comment\n" +
+		"@cert-authority *.example.com ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIFrM5IOS8n3Yt7xIX8kNnnSEV6m0d9QZpv1xH6Z3q7mE\n" +
+		"core1@10.0.0.1 ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIBxv4d3GLrmWs0Y9gYD8o1Mw3qUbhdmhpBrOu0DLo0Ry\n"
+	if err := ioutil.WriteFile(filename, []byte(knownHosts), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := List(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("incorrect number of entries. Expected 3, got %d", len(entries))
+	}
+	if entries[0].Marker != "revoked" || entries[0].Device != "core1" || entries[0].Address != "10.0.0.1" || entries[0].KeyType != "ssh-rsa" {
+		t.Errorf("incorrect revoked entry: %#v", entries[0])
+	}
+	if entries[1].Marker != "cert-authority" || entries[1].Device != "*.example.com" || entries[1].KeyType != "ssh-ed25519" {
+		t.Errorf("incorrect cert-authority entry: %#v", entries[1])
+	}
+	if entries[2].Marker != "" || entries[2].Device != "core1" {
+		t.Errorf("incorrect plain entry: %#v", entries[2])
+	}
+
+	// Revoked keys stay revoked when the device's keys are forgotten
+	removed, err := Forget(filename, "core1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed != 1 {
+		t.Errorf("incorrect number of removed entries. Expected 1, got %d", removed)
+	}
+	entries, _ = List(filename)
+	if len(entries) != 2 || entries[0].Marker != "revoked" || entries[1].Marker != "cert-authority" {
+		t.Errorf("incorrect entries after forget: %#v", entries)
+	}
+}
@@ -30,7 +30,11 @@ func (v varSlice) Set(value string) error {
 
 	vars := strings.Split(value, ";")
 	for _, val := range vars {
-		sVar := strings.Split(val, ":")
+		if strings.TrimSpace(val) == "" {
+			continue
+		}
+		// Split only on the first colon so values may contain colons
+		sVar := strings.SplitN(val, ":", 2)
 		if len(sVar) != 2 {
 			return fmt.Errorf("No value given for %s", val)
 		}
@@ -39,12 +43,28 @@ func (v varSlice) Set(value string) error {
 	return nil
 }
 
+type stringSlice []string
+
+func (s *stringSlice) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringSlice) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
+
 var (
-	dryRun        bool     // flag
-	verbose       bool     // flag
-	debug         bool     // flag
-	inventoryFile string   // flag
-	cliVars       varSlice // flag
+	dryRun        bool        // flag
+	verbose       bool        // flag
+	debug         bool        // flag
+	inventoryFile string      // flag
+	cliVars       varSlice    // flag
+	cliVarFiles   stringSlice // flag
+	limit         stringSlice // flag
+	askPass       bool        // flag
+	askEnable     bool        // flag
+	forceProtect  bool        // flag
 )
 
 func init() {
@@ -52,18 +72,61 @@ func init() {
 	flag.BoolVar(&dryRun, "r", false, "Do everything up to but not including, actually running the script. Also lists affected devices")
 	flag.BoolVar(&verbose, "v", false, "Enable verbose output")
 	flag.BoolVar(&debug, "d", false, "Enable debug mode")
-	flag.StringVar(&inventoryFile, "i", "hosts", "Inventory file")
+	flag.StringVar(&inventoryFile, "i", "", "Inventory file, defaults to "+defaultInventoryFile)
 	flag.Var(cliVars, "var", "Extra variables")
+	flag.Var(&cliVarFiles, "var-file", "File of extra variables in YAML, JSON, or INI format")
+	flag.Var(&limit, "limit", "Further restrict the devices of a task, may be given multiple times")
+	flag.BoolVar(&askPass, "ask-pass", false, "Prompt for the remote password")
+	flag.BoolVar(&askEnable, "ask-enable", false, "Prompt for the Cisco enable password")
+	flag.BoolVar(&forceProtect, "force-protected", false, "Allow tasks to run on protected devices")
+	flag.StringVar(&configFile, "config", defaultConfigFile, "Inca Tool config file")
+	flag.StringVar(&knownHostsFile, "known-hosts", "known_hosts", "File used to store trusted host keys")
+	flag.StringVar(&vaultFile, "vault", "secrets.vault", "Vault file for inventory secrets")
+	flag.StringVar(&vaultKeyFile, "vault-key-file", "", "Key file used to unlock the vault instead of a passphrase")
+	flag.StringVar(&vaultNewKeyFile, "vault-new-key-file", "", "New key file used when rekeying the vault")
 }
 
 func main() {
 	start := time.Now()
 	flag.Parse()
 
+	config, err := loadToolConfig(configFile)
+	if err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+
 	// Set taskmanager package settings
 	taskmanager.SetVerbose(verbose)
 	taskmanager.SetDebug(debug)
 	taskmanager.SetDryRun(dryRun)
+	taskmanager.SetKnownHostsFile(knownHostsFile)
+	taskmanager.SetLimit(limit)
+	taskmanager.SetForceProtected(forceProtect)
+	taskmanager.SetMaxDevicesPerRun(config.maxDevicesPerRun)
+
+	// Devices from an HTTP source are added to every inventory
+	if config.inventoryHTTP != "" {
+		if err := devices.AddHTTPSource(config.inventoryHTTP); err != nil {
+			fmt.Printf("Error in inventory http setting of %s: %s\n", configFile, err.Error())
+			os.Exit(1)
+		}
+	}
+
+	// Inventory settings may reference secrets in the vault or prompt for them
+	devices.RegisterResolver("vault", resolveVaultSecret)
+	devices.RegisterPrompt("ask", resolveAsk)
+	devices.SetPrompting(!dryRun)
+
+	// Credentials to prompt for override the inventory
+	overrides := make(map[string]string)
+	if askPass {
+		overrides["remote_password"] = "ask"
+	}
+	if askEnable {
+		overrides["cisco_enable"] = "ask"
+	}
+	taskmanager.SetOverrides(overrides)
 
 	cliArgs := flag.Args()
 	cliArgsc := len(cliArgs)
@@ -93,11 +156,12 @@ func main() {
 				task.Inventory = inventoryFile
 			}
 			if task.Inventory == "" {
-				task.Inventory = "devices.conf"
+				task.Inventory = defaultInventoryFile
 			}
 			// Set variables given in the command line into the task
-			for k, v := range cliVars {
-				task.SetUserData(k, v)
+			if err := setCliVariables(task); err != nil {
+				fmt.Println(err.Error())
+				continue
 			}
 			taskmanager.RunTaskFile(task)
 		}
@@ -105,27 +169,29 @@ func main() {
 		for _, file := range cliArgs[1:] {
 			taskmanager.ValidateTaskFile(file)
 		}
-	} else if command == "version" { // Show version info
+	} else if command == "vault" && cliArgsc >= 2 { // Manage the secrets vault
+		if err := runVaultCommand(cliArgs[1:]); err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
 		os.Exit(0)
-	} else if command == "help" { // Show help info
-		printUsage()
+	} else if command == "hostkeys" && cliArgsc >= 2 { // Manage trusted host keys
+		if err := runHostKeysCommand(cliArgs[1:]); err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
 		os.Exit(0)
-	} else if command == "dev" && cliArgsc == 2 { // Dev stuff
-		d, err := devices.ParseFile(cliArgs[1])
-		if err != nil {
+	} else if command == "inventory" && cliArgsc >= 2 { // Inspect the inventory
+		if err := runInventoryCommand(cliArgs[1:], overrides); err != nil {
 			fmt.Println(err.Error())
-		} else {
-			for _, group := range d.Groups {
-				fmt.Printf("Group: %s\n", group.Name)
-				fmt.Printf("Group Settings: %#v\n", group.GetSettings())
-				for _, dev := range group.Devices {
-					fmt.Printf("   DeviceName: %s\n", dev.Name)
-					fmt.Printf("   Device Settings: %#v\n", dev.GetSettings())
-				}
-				fmt.Println("")
-			}
+			os.Exit(1)
 		}
 		os.Exit(0)
+	} else if command == "version" { // Show version info
+		os.Exit(0)
+	} else if command == "help" { // Show help info
+		printUsage()
+		os.Exit(0)
 	} else {
 		printUsage()
 		os.Exit(0)
@@ -135,6 +201,24 @@ func main() {
 	fmt.Printf("\nExecution completed in %s\n", end)
 }
 
+// setCliVariables sets variables from the -var-file and -var flags into the task.
+// Variables given with -var take precedence.
+func setCliVariables(task *parser.TaskFile) error {
+	for _, varFile := range cliVarFiles {
+		vars, err := devices.ParseVarsFile(varFile)
+		if err != nil {
+			return err
+		}
+		for k, v := range vars {
+			task.SetUserData(k, v)
+		}
+	}
+	for k, v := range cliVars {
+		task.SetUserData(k, v)
+	}
+	return nil
+}
+
 func printHeader() {
 	fmt.Printf(`Inca Builder, version %s
 Copyright (C) 2015 Onesimus Systems
@@ -149,13 +233,31 @@ func printUsage() {
 	fmt.Printf(`Usage: %s [options] [command] [task1 [task2 [task3]...]]
 
 Options:
+	-ask-enable Prompt for the Cisco enable password
+	-ask-pass Prompt for the remote password
+	-config file Inca Tool config file, defaults to inca.conf
 	-d Enable debug output and functions
+	-force-protected Allow tasks to run on protected devices
+	-known-hosts file File used to store trusted host keys, defaults to known_hosts
+	-limit expr Further restrict the devices of a task, may be given multiple times
 	-r Perform a dry run and list the affected hosts
 	-v Enable verbose output
+	-var "key:value;key2:value2" Set extra variables
+	-var-file file Load extra variables from a YAML, JSON, or INI file
+	-vault file Vault file for inventory secrets, defaults to secrets.vault
+	-vault-key-file file Unlock the vault with a key file instead of a passphrase
+	-vault-new-key-file file New key file to use with "vault rekey"
 
 Commands:
 	run Run the given task files
 	test Test task files for errors
+	vault create|edit|view|rekey [file] Manage the encrypted secrets vault
+	hostkeys list|forget <device> Manage trusted host keys
+	inventory list|groups|show <device> [task]|graph|lint [-json] Inspect the inventory
+	inventory add <device> <group> [key=value ...] Add a device to a group in the inventory
+	inventory set|unset <device|group> key=value ... Change settings in the inventory
+	inventory rm <device|group> [group] Remove a device or group from the inventory
+	inventory disable <device> [-reason text]|enable <device> Disable or enable a device in the inventory
 	version Show version information
 	help Show this usage information
 `, os.Args[0])
@@ -0,0 +1,584 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/lfkeitel/inca-tool/devices"
+	"github.com/lfkeitel/inca-tool/parser"
+)
+
+const inventoryUsage = `Usage: inventory list|groups|show <device> [task]|graph|lint [-json]
+       inventory add <device> <group> [key=value ...]
+       inventory set <device|group> key=value [key=value ...]
+       inventory unset <device|group> key [key ...]
+       inventory rm <device|group> [group]
+       inventory disable <device> [-reason text]
+       inventory enable <device>`
+
+// inventoryGroup is the JSON form of a group
+type inventoryGroup struct {
+	Name     string            `json:"name"`
+	Devices  []string          `json:"devices"`
+	Children []string          `json:"children"`
+	Settings map[string]string `json:"settings"`
+}
+
+// inventoryNode is a group in the JSON form of the group graph
+type inventoryNode struct {
+	Name     string           `json:"name"`
+	Devices  []string         `json:"devices"`
+	Children []*inventoryNode `json:"children"`
+}
+
+// inventorySetting is the JSON form of a device setting
+type inventorySetting struct {
+	Value  string `json:"value"`
+	Source string `json:"source"`
+}
+
+// inventoryOut is where inventory commands print their output
+var inventoryOut io.Writer = os.Stdout
+
+// runInventoryCommand runs the inventory subcommand given in args. Settings in
+// overrides are shown as given on the command line.
+func runInventoryCommand(args []string, overrides map[string]string) error {
+	rest, jsonOutput, reason, err := parseInventoryArgs(args)
+	if err != nil {
+		return err
+	}
+
+	if inventoryFile == "" {
+		inventoryFile = defaultInventoryFile
+	}
+	if _, err := os.Stat(inventoryFile); os.IsNotExist(err) {
+		return fmt.Errorf("Inventory file %s does not exist, give the inventory file with -i", inventoryFile)
+	}
+
+	list, err := devices.ParseFile(inventoryFile)
+	if err != nil {
+		return err
+	}
+	list.SetOverrides(overrides)
+
+	switch rest[0] {
+	case "list":
+		return inventoryList(list, jsonOutput)
+	case "groups":
+		return inventoryGroups(list, jsonOutput)
+	case "show":
+		if len(rest) < 2 || len(rest) > 3 {
+			return errors.New(inventoryUsage)
+		}
+		device, exists := list.Devices[rest[1]]
+		if !exists {
+			return fmt.Errorf("Device %s not found", rest[1])
+		}
+		// Settings from a task are shown as they would be when running it
+		if len(rest) == 3 {
+			task, err := parser.ParseFile(rest[2])
+			if err != nil {
+				return err
+			}
+			list.SetTaskSettings(task.Settings)
+		}
+		return inventoryShow(device, jsonOutput)
+	case "graph":
+		return inventoryGraph(list, jsonOutput)
+	case "lint":
+		return inventoryLint(list, jsonOutput)
+	case "add":
+		if len(rest) < 3 {
+			return errors.New(inventoryUsage)
+		}
+		return inventoryAdd(list, rest[1], rest[2], rest[3:])
+	case "set", "unset":
+		if len(rest) < 3 {
+			return errors.New(inventoryUsage)
+		}
+		return inventorySet(list, rest[0] == "unset", rest[1], rest[2:])
+	case "rm":
+		if len(rest) < 2 || len(rest) > 3 {
+			return errors.New(inventoryUsage)
+		}
+		group := ""
+		if len(rest) == 3 {
+			group = rest[2]
+		}
+		return inventoryRemove(list, rest[1], group)
+	case "disable", "enable":
+		if len(rest) != 2 {
+			return errors.New(inventoryUsage)
+		}
+		device, exists := list.Devices[rest[1]]
+		if !exists {
+			return fmt.Errorf("Device %s not found", rest[1])
+		}
+		if rest[0] == "disable" {
+			return inventoryDisable(device, reason)
+		}
+		return inventoryEnable(device)
+	}
+
+	return fmt.Errorf("Unknown inventory command %s", rest[0])
+}
+
+// parseInventoryArgs separates the -json and -reason flags from the command and its arguments
+func parseInventoryArgs(args []string) (rest []string, jsonOutput bool, reason string, err error) {
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "-json" || arg == "--json" {
+			jsonOutput = true
+			continue
+		}
+		if arg == "-reason" || arg == "--reason" {
+			if i+1 == len(args) {
+				return nil, false, "", errors.New(inventoryUsage)
+			}
+			i++
+			reason = args[i]
+			continue
+		}
+		if strings.HasPrefix(arg, "-reason=") || strings.HasPrefix(arg, "--reason=") {
+			reason = arg[strings.Index(arg, "=")+1:]
+			continue
+		}
+		rest = append(rest, arg)
+	}
+	if len(rest) == 0 {
+		return nil, false, "", errors.New(inventoryUsage)
+	}
+	return rest, jsonOutput, reason, nil
+}
+
+func inventoryList(list *devices.DeviceList, jsonOutput bool) error {
+	names := sortedDeviceNames(list)
+
+	if jsonOutput {
+		type device struct {
+			Name    string   `json:"name"`
+			Address string   `json:"address"`
+			Groups  []string `json:"groups"`
+		}
+		out := make([]device, len(names))
+		for i, name := range names {
+			d := list.Devices[name]
+			out[i] = device{Name: name, Address: deviceAddress(d), Groups: d.Groups}
+		}
+		return printJSON(out)
+	}
+
+	w := tabwriter.NewWriter(inventoryOut, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "DEVICE\tADDRESS\tGROUPS")
+	for _, name := range names {
+		d := list.Devices[name]
+		fmt.Fprintf(w, "%s\t%s\t%s\n", name, deviceAddress(d), strings.Join(d.Groups, ", "))
+	}
+	return w.Flush()
+}
+
+func inventoryGroups(list *devices.DeviceList, jsonOutput bool) error {
+	names := sortedGroupNames(list)
+
+	if jsonOutput {
+		out := make([]inventoryGroup, len(names))
+		for i, name := range names {
+			group := list.Groups[name]
+			out[i] = inventoryGroup{
+				Name:     name,
+				Devices:  deviceNames(group.AllDevices()),
+				Children: append([]string{}, group.Children...),
+				Settings: group.GetSettings(),
+			}
+		}
+		return printJSON(out)
+	}
+
+	w := tabwriter.NewWriter(inventoryOut, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "GROUP\tDEVICES\tCHILDREN\tSETTINGS")
+	for _, name := range names {
+		group := list.Groups[name]
+		fmt.Fprintf(w, "%s\t%d\t%s\t%d\n", name, len(group.AllDevices()), strings.Join(group.Children, ", "), len(group.GetSettings()))
+	}
+	return w.Flush()
+}
+
+func inventoryShow(device *devices.Device, jsonOutput bool) error {
+	names := device.SettingNames()
+
+	if jsonOutput {
+		settings := make(map[string]inventorySetting, len(names))
+		for _, name := range names {
+			value, source := device.SettingSource(name)
+			settings[name] = inventorySetting{Value: value, Source: source}
+		}
+		return printJSON(struct {
+			Name     string                      `json:"name"`
+			Groups   []string                    `json:"groups"`
+			Settings map[string]inventorySetting `json:"settings"`
+		}{device.Name, device.Groups, settings})
+	}
+
+	fmt.Fprintf(inventoryOut, "Device: %s\n", device.Name)
+	fmt.Fprintf(inventoryOut, "Groups: %s\n\n", strings.Join(device.Groups, ", "))
+	w := tabwriter.NewWriter(inventoryOut, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "SETTING\tVALUE\tSOURCE")
+	for _, name := range names {
+		value, source := device.SettingSource(name)
+		fmt.Fprintf(w, "%s\t%s\t%s\n", name, value, source)
+	}
+	return w.Flush()
+}
+
+func inventoryGraph(list *devices.DeviceList, jsonOutput bool) error {
+	var roots []*inventoryNode
+	for _, name := range sortedGroupNames(list) {
+		if len(list.Groups[name].Parents()) == 0 {
+			roots = append(roots, graphNode(list, list.Groups[name]))
+		}
+	}
+
+	if jsonOutput {
+		return printJSON(roots)
+	}
+
+	var printNode func(node *inventoryNode, indent string)
+	printNode = func(node *inventoryNode, indent string) {
+		fmt.Fprintf(inventoryOut, "%s[%s]\n", indent, node.Name)
+		for _, child := range node.Children {
+			printNode(child, indent+"  ")
+		}
+		for _, device := range node.Devices {
+			fmt.Fprintf(inventoryOut, "%s  %s\n", indent, device)
+		}
+	}
+	for _, root := range roots {
+		printNode(root, "")
+	}
+	return nil
+}
+
+// inventoryLint prints problems with the inventory. An error is returned if any
+// of the problems are errors.
+func inventoryLint(list *devices.DeviceList, jsonOutput bool) error {
+	issues := devices.Lint(list)
+	errorCount := 0
+	for _, issue := range issues {
+		if issue.Level == devices.LintError {
+			errorCount++
+		}
+	}
+
+	if jsonOutput {
+		if issues == nil {
+			issues = []devices.LintIssue{}
+		}
+		if err := printJSON(issues); err != nil {
+			return err
+		}
+	} else {
+		for _, issue := range issues {
+			fmt.Fprintf(inventoryOut, "%s: %s\n", issue.Level, issue.Message)
+		}
+		fmt.Fprintf(inventoryOut, "%d error(s), %d warning(s)\n", errorCount, len(issues)-errorCount)
+	}
+
+	if errorCount > 0 {
+		return fmt.Errorf("Inventory %s has %d error(s)", inventoryFile, errorCount)
+	}
+	return nil
+}
+
+// inventoryAdd adds a device to a group. The group is added to the inventory file if it doesn't exist.
+func inventoryAdd(list *devices.DeviceList, name, group string, args []string) error {
+	settings, err := settingArgs(args)
+	if err != nil {
+		return err
+	}
+	if _, exists := list.Devices[name]; exists && len(settings) > 0 {
+		return fmt.Errorf("Device %s already exists, use set to change its settings", name)
+	}
+
+	var doc *devices.Document
+	if g, exists := list.Groups[group]; exists {
+		doc, err = g.Document()
+	} else {
+		doc, err = devices.OpenDocument(inventoryFile)
+	}
+	if err != nil {
+		return err
+	}
+	if err := doc.AddDevice(group, name, settings); err != nil {
+		return err
+	}
+	if err := saveInventoryDocument(doc); err != nil {
+		return err
+	}
+	fmt.Fprintf(inventoryOut, "Added %s to group %s in %s\n", name, group, doc.Filename())
+	return nil
+}
+
+// inventorySet sets or removes settings of a device or group. Devices are checked before groups.
+func inventorySet(list *devices.DeviceList, unset bool, name string, args []string) error {
+	settings := make(map[string]string)
+	if unset {
+		for _, key := range args {
+			settings[key] = ""
+		}
+	} else {
+		var err error
+		if settings, err = settingArgs(args); err != nil {
+			return err
+		}
+	}
+
+	var doc *devices.Document
+	var err error
+	var set func(key, value string) error
+	var remove func(key string) error
+
+	if device, exists := list.Devices[name]; exists {
+		doc, err = device.Document()
+		set = func(key, value string) error { return doc.SetDeviceSetting(name, key, value) }
+		remove = func(key string) error { return doc.DeleteDeviceSetting(name, key) }
+	} else if group, exists := list.Groups[name]; exists || name == "global" {
+		if exists {
+			doc, err = group.Document()
+		} else {
+			doc, err = devices.OpenDocument(inventoryFile)
+		}
+		set = func(key, value string) error { return doc.SetGroupSetting(name, key, value) }
+		remove = func(key string) error { return doc.DeleteGroupSetting(name, key) }
+	} else {
+		return fmt.Errorf("Group or device %s not found", name)
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, key := range sortedKeys(settings) {
+		if unset {
+			err = remove(key)
+		} else {
+			err = set(key, settings[key])
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if err := saveInventoryDocument(doc); err != nil {
+		return err
+	}
+	fmt.Fprintf(inventoryOut, "Changed %s in %s\n", name, doc.Filename())
+	return nil
+}
+
+// inventoryRemove removes a device from a group or every group, or removes a group
+func inventoryRemove(list *devices.DeviceList, name, group string) error {
+	var doc *devices.Document
+	var err error
+
+	if device, exists := list.Devices[name]; exists {
+		if g, exists := list.Groups[group]; exists {
+			doc, err = g.Document()
+		} else if group != "" {
+			return fmt.Errorf("Group %s not found", group)
+		} else {
+			doc, err = device.Document()
+		}
+		if err == nil {
+			err = doc.RemoveDevice(name, group)
+		}
+	} else if g, exists := list.Groups[name]; exists && group == "" {
+		doc, err = g.Document()
+		if err == nil {
+			err = doc.RemoveGroup(name)
+		}
+	} else {
+		return fmt.Errorf("Device %s not found", name)
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := saveInventoryDocument(doc); err != nil {
+		return err
+	}
+	fmt.Fprintf(inventoryOut, "Removed %s from %s\n", name, doc.Filename())
+
+	// Other files may still declare the device
+	if list, err := devices.ParseFile(inventoryFile); err == nil && group == "" {
+		if _, exists := list.Devices[name]; exists {
+			fmt.Fprintf(inventoryOut, "%s is still declared in another file of the inventory\n", name)
+		}
+	}
+	return nil
+}
+
+// inventoryDisable sets the disabled setting on the line declaring device in the inventory
+func inventoryDisable(device *devices.Device, reason string) error {
+	doc, err := device.Document()
+	if err != nil {
+		return err
+	}
+	if err := doc.SetDeviceSetting(device.Name, "disabled", "true"); err != nil {
+		return err
+	}
+	if reason != "" {
+		err = doc.SetDeviceSetting(device.Name, "disabled_reason", reason)
+	} else {
+		err = doc.DeleteDeviceSetting(device.Name, "disabled_reason")
+	}
+	if err != nil {
+		return err
+	}
+	if err := saveInventoryDocument(doc); err != nil {
+		return err
+	}
+	fmt.Fprintf(inventoryOut, "Disabled %s\n", device.Name)
+	return nil
+}
+
+// inventoryEnable removes the disabled setting from the line declaring device in the inventory.
+// If a group of the device disables it, the device is given disabled=false.
+func inventoryEnable(device *devices.Device) error {
+	if !device.IsDisabled() {
+		return fmt.Errorf("Device %s isn't disabled", device.Name)
+	}
+	_, source := device.SettingSource("disabled")
+	if source == "override" || source == "task" {
+		return fmt.Errorf("Device %s is disabled by a %s setting", device.Name, source)
+	}
+
+	doc, err := device.Document()
+	if err != nil {
+		return err
+	}
+	if source == "device" {
+		err = doc.DeleteDeviceSetting(device.Name, "disabled")
+	} else {
+		err = doc.SetDeviceSetting(device.Name, "disabled", "false")
+	}
+	if err == nil {
+		err = doc.DeleteDeviceSetting(device.Name, "disabled_reason")
+	}
+	if err != nil {
+		return err
+	}
+	if err := saveInventoryDocument(doc); err != nil {
+		return err
+	}
+	fmt.Fprintf(inventoryOut, "Enabled %s\n", device.Name)
+	return nil
+}
+
+// saveInventoryDocument saves a changed inventory file. If the inventory can't be read
+// after the change, the file is put back the way it was.
+func saveInventoryDocument(doc *devices.Document) error {
+	info, err := os.Stat(doc.Filename())
+	if err != nil {
+		return err
+	}
+	original, err := ioutil.ReadFile(doc.Filename())
+	if err != nil {
+		return err
+	}
+	if err := doc.Save(); err != nil {
+		return err
+	}
+
+	if _, err := devices.ParseFile(inventoryFile); err != nil {
+		if restoreErr := ioutil.WriteFile(doc.Filename(), original, info.Mode()); restoreErr != nil {
+			return fmt.Errorf("The inventory is invalid after the change and couldn't be restored: %s: %s", err.Error(), restoreErr.Error())
+		}
+		return fmt.Errorf("The change wasn't saved because the inventory would be invalid: %s", err.Error())
+	}
+	return nil
+}
+
+// settingArgs reads key=value arguments
+func settingArgs(args []string) (map[string]string, error) {
+	settings := make(map[string]string, len(args))
+	for _, arg := range args {
+		parts := strings.SplitN(arg, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("Settings must be given as key=value, not %s", arg)
+		}
+		settings[parts[0]] = parts[1]
+	}
+	return settings, nil
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func graphNode(list *devices.DeviceList, group *devices.Group) *inventoryNode {
+	node := &inventoryNode{
+		Name:     group.Name,
+		Devices:  deviceNames(group.Devices),
+		Children: []*inventoryNode{},
+	}
+	for _, child := range group.Children {
+		node.Children = append(node.Children, graphNode(list, list.Groups[child]))
+	}
+	return node
+}
+
+// deviceAddress returns the address used to connect to the device
+func deviceAddress(d *devices.Device) string {
+	if address, _ := d.SettingSource("address"); address != "" {
+		return address
+	}
+	return d.Name
+}
+
+func deviceNames(list []*devices.Device) []string {
+	names := make([]string, len(list))
+	for i, d := range list {
+		names[i] = d.Name
+	}
+	return names
+}
+
+func sortedDeviceNames(list *devices.DeviceList) []string {
+	names := make([]string, 0, len(list.Devices))
+	for name := range list.Devices {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// sortedGroupNames returns the names of all groups except the global group
+func sortedGroupNames(list *devices.DeviceList) []string {
+	names := make([]string, 0, len(list.Groups))
+	for name := range list.Groups {
+		if name != "global" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func printJSON(v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(inventoryOut, string(data))
+	return nil
+}
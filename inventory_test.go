@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var testInventory = `
+[global]
+remote_user = netops
+
+[core] site=hq
+core1 address=10.0.0.1
+core2 address=10.0.0.2 remote_user=admin
+
+[access]
+sw1 address=10.0.1.1
+
+[all:children]
+core
+access
+`
+
+func TestParseInventoryArgs(t *testing.T) {
+	tests := []struct {
+		args       []string
+		rest       []string
+		jsonOutput bool
+		reason     string
+		err        bool
+	}{
+		{[]string{"list"}, []string{"list"}, false, "", false},
+		{[]string{"list", "-json"}, []string{"list"}, true, "", false},
+		{[]string{"--json", "show", "core1"}, []string{"show", "core1"}, true, "", false},
+		{[]string{"disable", "core1", "-reason", "RMA 1234"}, []string{"disable", "core1"}, false, "RMA 1234", false},
+		{[]string{"disable", "-reason=RMA", "core1"}, []string{"disable", "core1"}, false, "RMA", false},
+		{[]string{"disable", "core1", "--reason"}, nil, false, "", true},
+		{[]string{"-json"}, nil, false, "", true},
+		{[]string{}, nil, false, "", true},
+	}
+
+	for _, test := range tests {
+		rest, jsonOutput, reason, err := parseInventoryArgs(test.args)
+		if (err != nil) != test.err {
+			t.Errorf("%v: incorrect error %v", test.args, err)
+			continue
+		}
+		if !reflect.DeepEqual(rest, test.rest) || jsonOutput != test.jsonOutput || reason != test.reason {
+			t.Errorf("%v: incorrect result %v %t \"%s\"", test.args, rest, jsonOutput, reason)
+		}
+	}
+}
+
+func TestInventoryJSON(t *testing.T) {
+	dir, err := ioutil.TempDir("", "inca-inventory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "devices.conf")
+	if err := ioutil.WriteFile(filename, []byte(testInventory), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	inventoryFile = filename
+	defer func() {
+		inventoryFile = ""
+		inventoryOut = os.Stdout
+	}()
+
+	run := func(v interface{}, args ...string) {
+		out := &bytes.Buffer{}
+		inventoryOut = out
+		if err := runInventoryCommand(append(args, "-json"), nil); err != nil {
+			t.Fatalf("%v: %s", args, err)
+		}
+		if err := json.Unmarshal(out.Bytes(), v); err != nil {
+			t.Fatalf("%v: invalid JSON: %s\n%s", args, err, out.String())
+		}
+	}
+
+	var list []struct {
+		Name    string   `json:"name"`
+		Address string   `json:"address"`
+		Groups  []string `json:"groups"`
+	}
+	run(&list, "list")
+	if len(list) != 3 || list[0].Name != "core1" || list[0].Address != "10.0.0.1" || list[2].Groups[0] != "access" {
+		t.Errorf("incorrect list output: %+v", list)
+	}
+
+	var groups []inventoryGroup
+	run(&groups, "groups")
+	names := make([]string, len(groups))
+	for i, group := range groups {
+		names[i] = group.Name
+	}
+	if strings.Join(names, ",") != "access,all,core" {
+		t.Errorf("incorrect groups: %v", names)
+	}
+	for _, group := range groups {
+		if group.Name == "all" && len(group.Devices) != 3 {
+			t.Errorf("group all should have every device, got %v", group.Devices)
+		}
+	}
+
+	var show struct {
+		Name     string                      `json:"name"`
+		Settings map[string]inventorySetting `json:"settings"`
+	}
+	run(&show, "show", "core2")
+	if show.Settings["remote_user"] != (inventorySetting{"admin", "device"}) || show.Settings["site"] != (inventorySetting{"hq", "group core"}) {
+		t.Errorf("incorrect show output: %+v", show)
+	}
+
+	var graph []*inventoryNode
+	run(&graph, "graph")
+	if len(graph) != 1 || graph[0].Name != "all" || len(graph[0].Children) != 2 {
+		t.Errorf("incorrect graph output: %+v", graph)
+	}
+
+	var issues []struct {
+		Level   string `json:"level"`
+		Message string `json:"message"`
+	}
+	run(&issues, "lint")
+	if issues == nil {
+		t.Error("lint should print an empty list, not null")
+	}
+}
+
+func TestInventoryMissingFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "inca-inventory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	inventoryFile = filepath.Join(dir, "missing.conf")
+	defer func() { inventoryFile = "" }()
+	err = runInventoryCommand([]string{"list"}, nil)
+	if err == nil || !strings.Contains(err.Error(), "-i") {
+		t.Errorf("missing inventory should say to use -i, got %v", err)
+	}
+}
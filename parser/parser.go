@@ -12,12 +12,15 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+
+	"github.com/lfkeitel/inca-tool/devices"
 )
 
 const (
 	modeRoot = iota
 	modeCommand
 	modeDevices
+	modeSettings
 )
 
 var (
@@ -158,6 +161,10 @@ func (p *Parser) scan(scanner *bufio.Scanner) error {
 			if err := p.parseDeviceLine(lineRaw); err != nil {
 				return err
 			}
+		} else if p.runningMode == modeSettings {
+			if err := p.parseSettingLine(lineRaw); err != nil {
+				return err
+			}
 		} else {
 			if err := p.parseLine(lineRaw); err != nil {
 				return err
@@ -185,6 +192,10 @@ func (p *Parser) parseLine(line []byte) error {
 		p.runningMode = modeDevices
 		return nil
 	}
+	if bytes.Equal(setting, []byte("settings")) {
+		p.runningMode = modeSettings
+		return nil
+	}
 
 	if !p.reflected {
 		p.mainReflect = reflect.ValueOf(p.task)
@@ -334,6 +345,35 @@ func (p *Parser) parseDeviceLine(line []byte) error {
 	return nil
 }
 
+func (p *Parser) parseSettingLine(line []byte) error {
+	matches := wsRegex.FindSubmatch(line)
+	if len(matches) == 0 {
+		return p.parseLine(line)
+	}
+	sigWs := string(matches[0])
+
+	if len(p.task.Settings) == 0 {
+		p.currentSigWs = sigWs
+		p.task.Settings = make(map[string]string)
+	} else {
+		if sigWs != p.currentSigWs {
+			return fmt.Errorf("Setting not in block, check indention. Line %d", p.currentLine)
+		}
+	}
+
+	// Split only on the first colon
+	parts := bytes.SplitN(bytes.TrimSpace(line), []byte(":"), 2)
+	if len(parts) != 2 {
+		return fmt.Errorf("Error on line %d of task file", p.currentLine)
+	}
+	setting := string(bytes.TrimSpace(parts[0]))
+	if _, set := p.task.Settings[setting]; set {
+		return fmt.Errorf("Cannot redeclare setting '%s'. Line %d", setting, p.currentLine)
+	}
+	p.task.Settings[setting] = string(bytes.TrimSpace(parts[1]))
+	return nil
+}
+
 func (p *Parser) finishUp() error {
 	if p.task.Concurrent <= 0 {
 		p.task.Concurrent = 300
@@ -342,6 +382,32 @@ func (p *Parser) finishUp() error {
 	if _, ok := p.task.Commands[p.task.DefaultCommandBlock]; !ok {
 		return errors.New("Default command block not declared")
 	}
+
+	if p.task.VarsFile != "" {
+		if err := p.loadVarsFile(p.task.VarsFile); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadVarsFile loads custom data from a variable file. Variables declared
+// in the task file take precedence over the variable file.
+func (p *Parser) loadVarsFile(filename string) error {
+	// Build file path relative to the task file
+	if !filepath.IsAbs(filename) && p.currentFile != "" {
+		filename = filepath.Join(filepath.Dir(p.currentFile), filename)
+	}
+
+	vars, err := devices.ParseVarsFile(filename)
+	if err != nil {
+		return err
+	}
+	for k, v := range vars {
+		if _, set := p.task.Metadata["_"+k]; !set {
+			p.task.Metadata["_"+k] = v
+		}
+	}
 	return nil
 }
 
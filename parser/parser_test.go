@@ -166,3 +166,31 @@ func compareTasks(t *TaskFile, i int) error {
 	}
 	return nil
 }
+
+func TestSettingsParse(t *testing.T) {
+	file := testFileHeader + `
+devices:
+    local
+settings:
+    remote_user: netops
+    protocol: telnet
+commands:
+    show version`
+
+	parsed, err := ParseString(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]string{
+		"remote_user": "netops",
+		"protocol":    "telnet",
+	}
+	if !reflect.DeepEqual(parsed.Settings, expected) {
+		t.Errorf("Settings not equal:\n%#v\n\n%#v\n\n", expected, parsed.Settings)
+	}
+
+	if _, err := ParseString(file + "\nsettings:\n    protocol: ssh"); err == nil {
+		t.Error("Redeclared setting parsed but should have failed")
+	}
+}
@@ -13,11 +13,14 @@ type TaskFile struct {
 	Metadata map[string]string
 
 	Concurrent int32
+	MaxDevices int32
 	Template   string
 	Prompt     string
+	VarsFile   string
 
 	Inventory string
 	Devices   []string
+	Settings  map[string]string
 
 	currentBlock        string
 	DefaultCommandBlock string
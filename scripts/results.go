@@ -0,0 +1,37 @@
+package scripts
+
+import (
+	"regexp"
+	"strings"
+)
+
+var (
+	// Scripts report the protocol used to connect with a line such as "INCA_PROTOCOL=ssh"
+	protocolMarkerRegex = regexp.MustCompile(`(?m)^INCA_PROTOCOL=(\w+)`)
+)
+
+// HostResult is the outcome of running a task on a single host
+type HostResult struct {
+	Name     string
+	Address  string
+	Protocol string
+	Err      error
+}
+
+// Failed returns if the task failed on the host
+func (r *HostResult) Failed() bool {
+	return r.Err != nil
+}
+
+// usedProtocol determines the protocol used to connect to a host. If multiple protocols
+// were allowed, the script's output says which one was used.
+func usedProtocol(protocols, output string) string {
+	if !strings.Contains(protocols, ",") {
+		return protocols
+	}
+	match := protocolMarkerRegex.FindStringSubmatch(output)
+	if match == nil {
+		return ""
+	}
+	return match[1]
+}
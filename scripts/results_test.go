@@ -0,0 +1,54 @@
+package scripts
+
+import "testing"
+
+func TestUsedProtocol(t *testing.T) {
+	tests := []struct {
+		name, protocols, output, expected string
+	}{
+		{"single protocol", "telnet", "", "telnet"},
+		{"single protocol ignores marker", "ssh", "\nINCA_PROTOCOL=telnet\n", "ssh"},
+		{
+			"first protocol",
+			"ssh,telnet",
+			"spawn ssh -p 22 admin@10.0.0.1\r\nadmin@10.0.0.1's password: \r\ncore1#\r\nINCA_PROTOCOL=ssh\r\nshow version\r\n",
+			"ssh",
+		},
+		{
+			"fallback after connection refused",
+			"ssh,telnet",
+			"spawn ssh -p 22 admin@10.0.0.1\r\nssh: connect to host 10.0.0.1 port 22: Connection refused\r\nspawn telnet 10.0.0.1 23\r\nUsername: admin\r\nPassword: \r\ncore1#\r\nINCA_PROTOCOL=telnet\r\n",
+			"telnet",
+		},
+		{
+			"fallback after timeout",
+			"ssh,telnet",
+			"spawn ssh -p 22 admin@10.0.0.1\r\nspawn telnet 10.0.0.1 23\r\nUsername: admin\r\nPassword: \r\ncore1>\r\nINCA_PROTOCOL=telnet\r\n",
+			"telnet",
+		},
+		{
+			"fallback after eof",
+			"telnet,ssh",
+			"spawn telnet 10.0.0.1 23\r\nConnection closed by foreign host.\r\nspawn ssh -p 22 admin@10.0.0.1\r\nadmin@10.0.0.1's password: \r\ncore1#\r\nINCA_PROTOCOL=ssh\r\n",
+			"ssh",
+		},
+		{
+			"no protocol connected",
+			"ssh,telnet",
+			"spawn ssh -p 22 admin@10.0.0.1\r\nssh: connect to host 10.0.0.1 port 22: Connection refused\r\nspawn telnet 10.0.0.1 23\r\ntelnet: Unable to connect to remote host: Connection refused\r\n",
+			"",
+		},
+		{
+			"marker must start a line",
+			"ssh,telnet",
+			"core1# echo INCA_PROTOCOL=telnet\r\n",
+			"",
+		},
+	}
+
+	for _, test := range tests {
+		if protocol := usedProtocol(test.protocols, test.output); protocol != test.expected {
+			t.Errorf("%s: incorrect protocol. Expected \"%s\", got \"%s\"", test.name, test.expected, protocol)
+		}
+	}
+}
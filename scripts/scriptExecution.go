@@ -19,19 +19,26 @@ import (
 )
 
 var (
-	verbose = false
-	dryRun  = false
-	debug   = false
+	verbose        = false
+	dryRun         = false
+	debug          = false
+	knownHostsFile = "known_hosts"
 )
 
 // Execute script on devices based on the task file and extra arguments eargs.
-func Execute(devices *devices.DeviceList, task *parser.TaskFile, script string, eargs []string) error {
+// A result is returned for every device.
+func Execute(devices *devices.DeviceList, task *parser.TaskFile, script string, eargs []string) ([]*HostResult, error) {
+	return execute(devices, task, script, eargs, valueEscaper(task.Template))
+}
+
+// execute runs the script on the devices. Variable values are escaped with escape if not nil.
+func execute(devices *devices.DeviceList, task *parser.TaskFile, script string, eargs []string, escape func(string) string) ([]*HostResult, error) {
 	// Make sure base script exists
 	if _, err := os.Stat(script); os.IsNotExist(err) {
-		return fmt.Errorf("Script file does not exist: %s\n", script)
+		return nil, fmt.Errorf("Script file does not exist: %s\n", script)
 	}
 	// Run task
-	return runTask(devices, task, script, eargs)
+	return runTask(devices, task, script, eargs, escape)
 }
 
 // SetVerbose enables or disables verbose output
@@ -49,18 +56,23 @@ func SetDebug(setting bool) {
 	debug = setting
 }
 
+// SetKnownHostsFile sets the file used to store host keys
+func SetKnownHostsFile(filename string) {
+	knownHostsFile, _ = filepath.Abs(filename)
+}
+
 // ProcessScriptCommand processes an _s special command
-func ProcessScriptCommand(cmd string, task *parser.TaskFile, devices *devices.DeviceList) error {
+func ProcessScriptCommand(cmd string, task *parser.TaskFile, devices *devices.DeviceList) ([]*HostResult, error) {
 	// Separate the filename from the arguments
 	cmdPieces := strings.Split(cmd, "--")
 	// Make sure we have enough pieces
 	if cmdPieces[0] == "" {
-		return fmt.Errorf("'_s' must have a filename")
+		return nil, fmt.Errorf("'_s' must have a filename")
 	}
 	// Get the absolute filepath for safety
 	script, err := filepath.Abs(strings.TrimSpace(cmdPieces[0]))
 	if err != nil {
-		return err
+		return nil, err
 	}
 	// Build the argument list
 	var args []string
@@ -71,7 +83,8 @@ func ProcessScriptCommand(cmd string, task *parser.TaskFile, devices *devices.De
 		}
 	}
 
-	return Execute(devices, task, script, args)
+	// The script can be in any language so variables are inserted as they are
+	return execute(devices, task, script, args, nil)
 }
 
 // GenerateBaseScriptFile generates a script based on the template and data given. It returns the path to the script
@@ -84,11 +97,12 @@ func GenerateBaseScriptFile(template string, data string, taskVars map[string]st
 		return "", err
 	}
 	// Insert the main section
-	if err := insertVariables(tmpFilename, map[string]string{"main": data}); err != nil {
+	if err := insertVariables(tmpFilename, map[string]string{"main": data}, nil); err != nil {
 		return "", err
 	}
 	// Process custom variable data
-	if err := insertVariables(tmpFilename, taskVars); err != nil {
+	escape := valueEscaper(strings.TrimSuffix(filepath.Base(template), "-template.tmpl"))
+	if err := insertVariables(tmpFilename, taskVars, escape); err != nil {
 		return "", err
 	}
 
@@ -103,34 +117,44 @@ func GenerateBaseScriptFile(template string, data string, taskVars map[string]st
 	return tmpFilename, nil
 }
 
-func runTask(hosts *devices.DeviceList, task *parser.TaskFile, baseScript string, eargs []string) error {
+func runTask(hosts *devices.DeviceList, task *parser.TaskFile, baseScript string, eargs []string, escape func(string) string) ([]*HostResult, error) {
 	// Wait group for all hosts
 	var wg sync.WaitGroup
 	// Wait group to enforce maximum concurrent hosts
 	lg := us.NewLimitGroup(task.Concurrent)
+	// Variables shared by all hosts in this run
+	runVars := getRunVariables(time.Now())
+	// Each goroutine only writes its own result
+	results := make([]*HostResult, 0, len(hosts.Devices))
 
 	// For every host
 	for _, host := range hosts.Devices {
 		// Get variables
-		vars := getHostVariables(host)
+		vars := getHostVariables(host, runVars)
 		if verbose {
 			fmt.Printf("Configuring host %s (%s)\n", host.Name, vars["hostname"])
 		}
+		result := &HostResult{
+			Name:    host.Name,
+			Address: vars["hostname"],
+		}
+		results = append(results, result)
 
 		// Generate a host specific script file
 		hostScript := fmt.Sprintf("%s-%s.sh", baseScript, host.Name)
 		err := copyFileContents(baseScript, hostScript)
 		if err != nil {
 			fmt.Printf("Error configuring host %s: %s\n", host.Name, err.Error())
+			result.Err = err
 			continue
 		}
-		if err := insertVariables(hostScript, vars); err != nil {
-			return err
+		if err := insertVariables(hostScript, vars, escape); err != nil {
+			return nil, err
 		}
 
 		if debug && verbose {
 			fmt.Println("Script Variables:")
-			for i, v := range vars {
+			for i, v := range maskSecrets(host, vars) {
 				fmt.Printf("  %s: %s\n", i, v)
 			}
 		}
@@ -138,31 +162,34 @@ func runTask(hosts *devices.DeviceList, task *parser.TaskFile, baseScript string
 		// The magic, set off a goroutine to execute the script
 		wg.Add(1)
 		lg.Add(1)
-		go func(script, name, address string) {
+		go func(script, protocols string, result *HostResult) {
 			defer func() {
 				wg.Done()
 				lg.Done()
 			}()
-			runScript(script, eargs)
+			output, err := runScript(script, eargs)
+			result.Err = err
+			result.Protocol = usedProtocol(protocols, output)
 			if verbose {
-				fmt.Printf("Finished configuring host %s (%s)\n", name, address)
+				fmt.Printf("Finished configuring host %s (%s)\n", result.Name, result.Address)
 			}
 			if !debug {
 				// Remove host specific script file
 				os.Remove(script)
 			}
-		}(hostScript, host.Name, vars["hostname"])
+		}(hostScript, vars["protocol"], result)
 		// Wait for the next available host execution slot
 		lg.Wait()
 	}
 	// Wait for everybody
 	wg.Wait()
-	return nil
+	return results, nil
 }
 
-func runScript(sfn string, args []string) error {
+// runScript executes the script sfn and returns its standard output
+func runScript(sfn string, args []string) (string, error) {
 	if dryRun {
-		return nil
+		return "", nil
 	}
 
 	cmd := exec.Command(sfn, args...)
@@ -176,9 +203,9 @@ func runScript(sfn string, args []string) error {
 		if debug {
 			fmt.Println(out.String())
 		}
-		return err
+		return out.String(), err
 	}
-	return nil
+	return out.String(), nil
 }
 
 func copyFileContents(src, dst string) error {
@@ -0,0 +1,160 @@
+package scripts
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// templateProcs returns the definitions of the procs in the expect template so they can be run
+// without connecting to anything
+func templateProcs(t *testing.T, names ...string) string {
+	data, err := ioutil.ReadFile("../templates/expect-template.tmpl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := string(data)
+
+	var procs []string
+	for _, name := range names {
+		start := strings.Index(template, "\nproc "+name+" ")
+		if start < 0 {
+			t.Fatalf("proc %s not found", name)
+		}
+		end := strings.Index(template[start:], "\n}\n")
+		if end < 0 {
+			t.Fatalf("proc %s isn't closed", name)
+		}
+		procs = append(procs, template[start:start+end+3])
+	}
+	return strings.Join(procs, "")
+}
+
+// expandProxyCommand expands the % sequences of a ProxyCommand the same as ssh
+func expandProxyCommand(command, host, port string) string {
+	return strings.NewReplacer("%%", "%", "%h", host, "%p", port).Replace(command)
+}
+
+func TestProxyCommand(t *testing.T) {
+	tclsh, err := exec.LookPath("tclsh")
+	if err != nil {
+		t.Skip("tclsh not found")
+	}
+
+	dir, err := ioutil.TempDir("", "inca-proxy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// A fake ssh prints its arguments so the quoting of the command can be checked
+	bin := filepath.Join(dir, "bin")
+	os.Mkdir(bin, 0755)
+	ioutil.WriteFile(filepath.Join(bin, "ssh"), []byte("#!/bin/sh\nfor arg in \"$@\"; do echo \"$arg\"; done\n"), 0755)
+	knownHosts := filepath.Join(dir, "known's hosts 100%")
+
+	for _, checking := range []string{"tofu", "strict"} {
+		script := filepath.Join(dir, "proxy.tcl")
+		text := `set hostname "10.0.0.1"
+set hostkeychecking "` + checking + `"
+set knownhosts "` + tclEscape(knownHosts) + `"
+set proxyuser "bob"
+set proxyhost "bastion"
+set proxyport "2222"
+` + templateProcs(t, "host_key_options", "proxy_command") + `
+puts -nonewline [proxy_command]
+`
+		if err := ioutil.WriteFile(script, []byte(text), 0644); err != nil {
+			t.Fatal(err)
+		}
+		command, err := exec.Command(tclsh, script).CombinedOutput()
+		if err != nil {
+			t.Fatalf("%s: proxy_command failed: %s %s", checking, err.Error(), command)
+		}
+
+		cmd := exec.Command("sh", "-c", expandProxyCommand(string(command), "10.0.0.1", "22"))
+		cmd.Env = append(os.Environ(), "PATH="+bin+":"+os.Getenv("PATH"))
+		output, err := cmd.Output()
+		if err != nil {
+			t.Fatalf("%s: ProxyCommand failed: %s", checking, err.Error())
+		}
+
+		strictness := "accept-new"
+		if checking == "strict" {
+			strictness = "yes"
+		}
+		// The jump host is verified the same as the device
+		expected := []string{
+			"-o", "StrictHostKeyChecking=" + strictness,
+			"-o", "UserKnownHostsFile=" + knownHosts,
+			"-o", "HashKnownHosts=no",
+			"-o", "HostKeyAlias=bastion",
+			"-p", "2222",
+			"-W", "10.0.0.1:22",
+			"bob@bastion",
+		}
+		if args := strings.Split(strings.TrimSuffix(string(output), "\n"), "\n"); !reflect.DeepEqual(args, expected) {
+			t.Errorf("%s: incorrect jump host ssh arguments.\nExpected %q\ngot      %q", checking, expected, args)
+		}
+	}
+}
+
+func TestSSHArgs(t *testing.T) {
+	tclsh, err := exec.LookPath("tclsh")
+	if err != nil {
+		t.Skip("tclsh not found")
+	}
+
+	dir, err := ioutil.TempDir("", "inca-ssh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	hostKeyOptions := []string{
+		"-o", "StrictHostKeyChecking=accept-new",
+		"-o", "UserKnownHostsFile=/tmp/known_hosts",
+		"-o", "HashKnownHosts=no",
+		"-o", "HostKeyAlias=core1@10.0.0.1",
+		"-p", "22",
+	}
+	keyOptions := []string{"-o", "PreferredAuthentications=publickey", "-o", "IdentitiesOnly=yes", "-i"}
+
+	tests := []struct {
+		name, sshKey, proxyJump string
+		expected                []string
+	}{
+		{"password", "", "", append(hostKeyOptions, "admin@10.0.0.1")},
+		{"key", "/home/admin/.ssh/id_ed25519", "", append(append(hostKeyOptions, keyOptions...), "/home/admin/.ssh/id_ed25519", "admin@10.0.0.1")},
+		{"key with a space", "/home/admin/my keys/id_rsa", "", append(append(hostKeyOptions, keyOptions...), "/home/admin/my keys/id_rsa", "admin@10.0.0.1")},
+	}
+
+	for _, test := range tests {
+		script := filepath.Join(dir, "ssh.tcl")
+		text := `set hostname "10.0.0.1"
+set username "admin"
+set sshport "22"
+set sshkey "` + tclEscape(test.sshKey) + `"
+set proxyjump "` + tclEscape(test.proxyJump) + `"
+set hostkeyalias "core1@10.0.0.1"
+set hostkeychecking "tofu"
+set knownhosts "/tmp/known_hosts"
+` + templateProcs(t, "host_key_options", "proxy_command", "ssh_args") + `
+puts -nonewline [join [ssh_args] "\n"]
+`
+		if err := ioutil.WriteFile(script, []byte(text), 0644); err != nil {
+			t.Fatal(err)
+		}
+		output, err := exec.Command(tclsh, script).CombinedOutput()
+		if err != nil {
+			t.Fatalf("%s: ssh_args failed: %s %s", test.name, err.Error(), output)
+		}
+		if args := strings.Split(string(output), "\n"); !reflect.DeepEqual(args, test.expected) {
+			t.Errorf("%s: incorrect ssh arguments.\nExpected %q\ngot      %q", test.name, test.expected, args)
+		}
+	}
+}
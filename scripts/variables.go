@@ -3,11 +3,17 @@ package scripts
 import (
 	"bytes"
 	"io/ioutil"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/lfkeitel/inca-tool/devices"
+	"github.com/lfkeitel/inca-tool/hostkeys"
 )
 
-func insertVariables(filename string, vars map[string]string) error {
+// insertVariables replaces each {{name}} in the file with the value of the variable. If escape
+// isn't nil, values are escaped with it so they're inserted as literal text.
+func insertVariables(filename string, vars map[string]string, escape func(string) string) error {
 	file, err := ioutil.ReadFile(filename)
 	if err != nil {
 		return err
@@ -17,6 +23,9 @@ func insertVariables(filename string, vars map[string]string) error {
 		if n[0] == '_' {
 			n = n[1:]
 		}
+		if escape != nil {
+			v = escape(v)
+		}
 		file = bytes.Replace(file, []byte("{{"+n+"}}"), []byte(v), -1)
 	}
 
@@ -26,18 +35,89 @@ func insertVariables(filename string, vars map[string]string) error {
 	return nil
 }
 
-func getHostVariables(host *devices.Device) map[string]string {
-	argList := make(map[string]string)
-	argList["protocol"] = host.GetSetting("protocol")
+// valueEscaper returns the function used to escape variable values for the language of
+// template. Nil is returned if values are inserted as they are.
+func valueEscaper(template string) func(string) string {
+	if template == "" || template == "expect" {
+		return tclEscape
+	}
+	return nil
+}
+
+// tclEscape escapes s so it's literal text inside a double quoted Tcl string, even when the
+// string is in a braced block such as the body of expect. Passwords may contain any character
+// and must never be evaluated as Tcl.
+func tclEscape(s string) string {
+	var buf bytes.Buffer
+	for _, c := range s {
+		switch c {
+		case '\\', '"', '$', '[', ']', '{', '}':
+			buf.WriteByte('\\')
+			buf.WriteRune(c)
+		case '\n':
+			buf.WriteString(`\n`)
+		case '\r':
+			buf.WriteString(`\r`)
+		default:
+			buf.WriteRune(c)
+		}
+	}
+	return buf.String()
+}
+
+// getRunVariables returns the built-in variables that are the same for every host in a run
+func getRunVariables(start time.Time) map[string]string {
+	return map[string]string{
+		"run.id":   strconv.FormatInt(start.UnixNano(), 10),
+		"run.time": start.Format(time.RFC3339),
+	}
+}
+
+func getHostVariables(host *devices.Device, runVars map[string]string) map[string]string {
+	// Every inventory setting is available to the script
+	argList := host.GetAllSettings()
+	for k, v := range runVars {
+		argList[k] = v
+	}
+	argList["device.name"] = host.Name
+	argList["device.groups"] = strings.Join(host.Groups, ",")
+
+	// Protocol may be an ordered list of protocols to try such as "ssh,telnet"
+	argList["protocol"] = strings.Replace(host.GetSetting("protocol"), " ", "", -1)
 	if argList["protocol"] == "" {
 		argList["protocol"] = "ssh"
 	}
 
+	// A protocol specific port takes precedence over the general port
+	argList["port"] = host.GetSetting("port")
+	argList["ssh_port"] = host.GetSetting("ssh_port")
+	if argList["ssh_port"] == "" {
+		argList["ssh_port"] = argList["port"]
+	}
+	if argList["ssh_port"] == "" {
+		argList["ssh_port"] = "22"
+	}
+	argList["telnet_port"] = host.GetSetting("telnet_port")
+	if argList["telnet_port"] == "" {
+		argList["telnet_port"] = argList["port"]
+	}
+	if argList["telnet_port"] == "" {
+		argList["telnet_port"] = "23"
+	}
+
 	argList["hostname"] = host.GetSetting("address")
 	if argList["hostname"] == "" {
 		argList["hostname"] = host.Name
 	}
 
+	// Host keys are verified against the tool's known hosts store
+	argList["host_key_checking"] = host.GetSetting("host_key_checking")
+	if argList["host_key_checking"] == "" {
+		argList["host_key_checking"] = "tofu"
+	}
+	argList["host_key_alias"] = hostkeys.Alias(host.Name, argList["hostname"])
+	argList["known_hosts_file"] = knownHostsFile
+
 	argList["remote_user"] = host.GetSetting("remote_user")
 	if argList["remote_user"] == "" {
 		argList["remote_user"] = "root"
@@ -50,5 +130,49 @@ func getHostVariables(host *devices.Device) map[string]string {
 		argList["cisco_enable"] = host.GetSetting("remote_password")
 	}
 
+	// Key authentication is only used when a key is given
+	argList["ssh_key"] = host.GetSetting("ssh_key")
+	argList["ssh_key_passphrase"] = host.GetSetting("ssh_key_passphrase")
+
+	// Jump host credentials default to the device's credentials
+	argList["proxy_jump"] = host.GetSetting("proxy_jump")
+	argList["proxy_user"] = host.GetSetting("proxy_user")
+	if argList["proxy_user"] == "" {
+		argList["proxy_user"] = argList["remote_user"]
+	}
+	argList["proxy_password"] = host.GetSetting("proxy_password")
+	if argList["proxy_password"] == "" {
+		argList["proxy_password"] = argList["remote_password"]
+	}
+
 	return argList
 }
+
+// maskSecrets returns a copy of vars safe to print. Secrets from external sources are masked,
+// including variables given a secret by default such as cisco_enable and proxy_password.
+func maskSecrets(host *devices.Device, vars map[string]string) map[string]string {
+	var secrets []string
+	for _, name := range host.SettingNames() {
+		if !host.IsExternal(name) {
+			continue
+		}
+		if secret := host.GetSetting(name); secret != "" {
+			secrets = append(secrets, secret)
+		}
+	}
+
+	masked := make(map[string]string, len(vars))
+	for name, value := range vars {
+		if host.IsExternal(name) {
+			value = "********"
+		}
+		for _, secret := range secrets {
+			if strings.Contains(value, secret) {
+				value = "********"
+				break
+			}
+		}
+		masked[name] = value
+	}
+	return masked
+}
@@ -0,0 +1,116 @@
+package scripts
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/lfkeitel/inca-tool/devices"
+)
+
+func TestMaskSecrets(t *testing.T) {
+	os.Setenv("INCA_TEST_SECRET", "hunter2")
+	defer os.Unsetenv("INCA_TEST_SECRET")
+
+	list, err := devices.ParseString(`
+[global]
+remote_user = netops
+remote_password = env:INCA_TEST_SECRET
+
+[core] proxy_jump=bastion
+core1 address=10.0.0.1
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vars := getHostVariables(list.Devices["core1"], getRunVariables(time.Now()))
+	if vars["cisco_enable"] != "hunter2" || vars["proxy_password"] != "hunter2" {
+		t.Fatalf("the password should be the default of cisco_enable and proxy_password: %v", vars)
+	}
+
+	masked := maskSecrets(list.Devices["core1"], vars)
+	for _, name := range []string{"remote_password", "cisco_enable", "proxy_password"} {
+		if masked[name] != "********" {
+			t.Errorf("variable %s should be masked, got \"%s\"", name, masked[name])
+		}
+	}
+	for _, name := range []string{"remote_user", "hostname", "proxy_jump"} {
+		if masked[name] != vars[name] {
+			t.Errorf("variable %s shouldn't be masked, got \"%s\"", name, masked[name])
+		}
+	}
+}
+
+func TestSSHKeyVariables(t *testing.T) {
+	os.Setenv("INCA_TEST_SECRET", "correct horse")
+	defer os.Unsetenv("INCA_TEST_SECRET")
+
+	list, err := devices.ParseString(`
+[linux] ssh_key=/home/netops/.ssh/id_ed25519 ssh_key_passphrase=env:INCA_TEST_SECRET
+server1 address=10.0.2.1
+
+[core]
+core1 remote_password=secret
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vars := getHostVariables(list.Devices["server1"], getRunVariables(time.Now()))
+	if vars["ssh_key"] != "/home/netops/.ssh/id_ed25519" || vars["ssh_key_passphrase"] != "correct horse" {
+		t.Errorf("incorrect key variables: \"%s\" \"%s\"", vars["ssh_key"], vars["ssh_key_passphrase"])
+	}
+	if masked := maskSecrets(list.Devices["server1"], vars); masked["ssh_key_passphrase"] != "********" {
+		t.Errorf("key passphrase should be masked, got \"%s\"", masked["ssh_key_passphrase"])
+	}
+
+	// Without a key the template uses password authentication
+	vars = getHostVariables(list.Devices["core1"], getRunVariables(time.Now()))
+	if key, ok := vars["ssh_key"]; !ok || key != "" {
+		t.Errorf("ssh_key should be set to an empty value without a key, got \"%s\" %t", key, ok)
+	}
+	if key, ok := vars["ssh_key_passphrase"]; !ok || key != "" {
+		t.Errorf("ssh_key_passphrase should be set to an empty value without a key, got \"%s\" %t", key, ok)
+	}
+}
+
+func TestTclEscape(t *testing.T) {
+	password := `a"b$c[d]\` + "{e} f;g\n"
+	if escaped := tclEscape(password); escaped != `a\"b\$c\[d\]\\\{e\} f;g\n` {
+		t.Errorf("incorrect escaped value %s", escaped)
+	}
+
+	dir, err := ioutil.TempDir("", "inca-script")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	script := filepath.Join(dir, "script.tcl")
+	template := `set password "{{remote_password}}"
+proc braced {} { return "{{remote_password}}" }
+puts -nonewline "$password|[braced]"
+`
+	if err := ioutil.WriteFile(script, []byte(template), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := insertVariables(script, map[string]string{"remote_password": password}, valueEscaper("expect")); err != nil {
+		t.Fatal(err)
+	}
+
+	// The script is only run if Tcl is installed
+	tclsh, err := exec.LookPath("tclsh")
+	if err != nil {
+		t.Skip("tclsh not found")
+	}
+	output, err := exec.Command(tclsh, script).CombinedOutput()
+	if err != nil {
+		t.Fatalf("script failed: %s %s", err.Error(), output)
+	}
+	if string(output) != password+"|"+password {
+		t.Errorf("incorrect password in script. Expected %q, got %q", password+"|"+password, output)
+	}
+}
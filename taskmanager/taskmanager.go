@@ -3,6 +3,8 @@ package taskmanager
 import (
 	"fmt"
 	"os"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/lfkeitel/inca-tool/devices"
@@ -11,9 +13,14 @@ import (
 )
 
 var (
-	verbose = false
-	dryRun  = false
-	debug   = false
+	verbose        = false
+	dryRun         = false
+	debug          = false
+	knownHostsFile = "known_hosts"
+	overrides      map[string]string
+	limit          []string
+	forceProtected = false
+	maxDevices     = 0
 )
 
 // SetVerbose enables or disables verbose output
@@ -31,11 +38,38 @@ func SetDebug(setting bool) {
 	debug = setting
 }
 
+// SetKnownHostsFile sets the file used to store host keys
+func SetKnownHostsFile(filename string) {
+	knownHostsFile = filename
+}
+
+// SetOverrides sets inventory settings that take precedence over all others
+func SetOverrides(settings map[string]string) {
+	overrides = settings
+}
+
+// SetLimit sets device selection terms that further restrict the devices of a task
+func SetLimit(terms []string) {
+	limit = terms
+}
+
+// SetForceProtected allows or refuses running tasks on protected devices
+func SetForceProtected(setting bool) {
+	forceProtected = setting
+}
+
+// SetMaxDevicesPerRun sets the number of devices a task may run on unless the task
+// allows more. Zero means no limit.
+func SetMaxDevicesPerRun(max int) {
+	maxDevices = max
+}
+
 func RunTaskFile(task *parser.TaskFile) {
 	// Set scripts package settings
 	scripts.SetVerbose(verbose)
 	scripts.SetDebug(debug)
 	scripts.SetDryRun(dryRun)
+	scripts.SetKnownHostsFile(knownHostsFile)
 
 	os.RemoveAll("tmp")
 	os.Mkdir("tmp", 0755)
@@ -57,6 +91,8 @@ func RunTaskFile(task *parser.TaskFile) {
 		fmt.Printf("Error loading devices: %s\n", err.Error())
 		return
 	}
+	deviceList.SetTaskSettings(task.Settings)
+	deviceList.SetOverrides(overrides)
 
 	deviceList, err = devices.Filter(deviceList, task.Devices)
 	if err != nil {
@@ -64,9 +100,32 @@ func RunTaskFile(task *parser.TaskFile) {
 		return
 	}
 
+	if len(limit) > 0 {
+		deviceList, err = devices.Limit(deviceList, limit)
+		if err != nil {
+			fmt.Printf("Error: %s\n", err.Error())
+			return
+		}
+	}
+
+	// Disabled devices are reported with the results
+	disabled := deviceList.Disabled()
+
 	// If no devices will be affected, exit
 	if len(deviceList.Devices) == 0 {
 		fmt.Println("No devices match running task. Exiting.")
+		printDisabled(disabled)
+		return
+	}
+
+	if err := checkSelection(deviceList, task); err != nil {
+		fmt.Printf("Error: %s\n", err.Error())
+		return
+	}
+
+	// Resolve settings from external sources before any device is configured
+	if err := deviceList.ResolveSettings(); err != nil {
+		fmt.Printf("Error: %s\n", err.Error())
 		return
 	}
 
@@ -75,9 +134,12 @@ func RunTaskFile(task *parser.TaskFile) {
 	if err != nil {
 		if parser.IsScriptRun(err) {
 			// Run straight script file if prompted
-			if err := scripts.ProcessScriptCommand(text, task, deviceList); err != nil {
+			results, err := scripts.ProcessScriptCommand(text, task, deviceList)
+			if err != nil {
 				fmt.Printf("Error executing task: %s\n", err.Error())
+				return
 			}
+			printResults(results, disabled)
 			return
 		}
 		fmt.Printf("Error compiling script: %s\n", err.Error())
@@ -107,7 +169,7 @@ func RunTaskFile(task *parser.TaskFile) {
 	}
 
 	// Execute the script (the dry run setting will stop before actual execution)
-	err = scripts.Execute(deviceList, task, scriptFilename, nil)
+	results, err := scripts.Execute(deviceList, task, scriptFilename, nil)
 	if err != nil {
 		fmt.Printf("Error executing task: %s\n", err.Error())
 		return
@@ -132,7 +194,88 @@ func RunTaskFile(task *parser.TaskFile) {
 		}
 	}
 
-	fmt.Printf("\nHosts touched: %d\n", len(deviceList.Devices))
+	printResults(results, disabled)
+}
+
+// checkSelection guards against running a task on more of the network than intended. Protected
+// devices are refused unless forced. A protected setting that isn't true or false is an error so
+// a typo never leaves a device unprotected. The number of devices may not be more than the task's
+// max devices setting, or the max devices per run if the task doesn't set one.
+func checkSelection(deviceList *devices.DeviceList, task *parser.TaskFile) error {
+	var protected []string
+	for name, device := range deviceList.Devices {
+		isProtected, err := device.Flag("protected")
+		if err != nil {
+			return fmt.Errorf("Device %s: %s", name, err.Error())
+		}
+		if isProtected {
+			protected = append(protected, name)
+		}
+	}
+	if len(protected) > 0 && !forceProtected {
+		sort.Strings(protected)
+		return fmt.Errorf("Task selects protected devices %s. Use -force-protected to run it anyway", strings.Join(protected, ", "))
+	}
+
+	count := len(deviceList.Devices)
+	if task.MaxDevices > 0 {
+		if count > int(task.MaxDevices) {
+			return fmt.Errorf("Task selects %d devices but its max devices is %d", count, task.MaxDevices)
+		}
+		return nil
+	}
+	if maxDevices > 0 && count > maxDevices {
+		return fmt.Errorf("Task selects %d devices which is more than the %d allowed per run. Set \"max devices: %d\" in the task to run it anyway", count, maxDevices, count)
+	}
+	return nil
+}
+
+// printResults prints a summary of the task results. Successful hosts are only listed in verbose mode.
+// Disabled devices are listed so they aren't forgotten.
+func printResults(results []*scripts.HostResult, disabled []*devices.Device) {
+	failed := 0
+	for _, result := range results {
+		if result.Failed() {
+			failed++
+		}
+	}
+
+	if verbose || failed > 0 {
+		fmt.Print("\nResults:\n")
+		for _, result := range results {
+			if result.Failed() {
+				fmt.Printf("  %s (%s): failed: %s\n", result.Name, result.Address, result.Err.Error())
+			} else if verbose {
+				fmt.Printf("  %s (%s): ok", result.Name, result.Address)
+				if result.Protocol != "" {
+					fmt.Printf(" via %s", result.Protocol)
+				}
+				fmt.Println("")
+			}
+		}
+	}
+
+	printDisabled(disabled)
+
+	fmt.Printf("\nHosts touched: %d\n", len(results))
+	if failed > 0 {
+		fmt.Printf("Hosts failed: %d\n", failed)
+	}
+}
+
+// printDisabled lists the disabled devices that were left out of the task with their reason
+func printDisabled(disabled []*devices.Device) {
+	if len(disabled) == 0 {
+		return
+	}
+	fmt.Printf("\nDisabled: %d\n", len(disabled))
+	for _, device := range disabled {
+		fmt.Printf("  %s", device.Name)
+		if reason := device.DisabledReason(); reason != "" {
+			fmt.Printf(": %s", reason)
+		}
+		fmt.Println("")
+	}
 }
 
 func ValidateTaskFile(filename string) {
@@ -161,6 +304,7 @@ func ValidateTaskFile(filename string) {
 		fmt.Printf("  Version: %s\n", task.GetMetadata("version"))
 
 		fmt.Printf("  Concurrent Devices: %d\n", task.Concurrent)
+		fmt.Printf("  Max Devices: %d\n", task.MaxDevices)
 		fmt.Printf("  Template: %s\n", task.Template)
 		fmt.Printf("  Inventory File: %s\n\n", task.Inventory)
 
@@ -172,6 +316,11 @@ func ValidateTaskFile(filename string) {
 			fmt.Printf("  %s: %s\n", k[1:], v)
 		}
 
+		fmt.Print("\n  ----Task Settings----\n")
+		for k, v := range task.Settings {
+			fmt.Printf("  %s: %s\n", k, v)
+		}
+
 		fmt.Print("\n  ----Task Device Block----\n")
 		for _, d := range task.Devices {
 			fmt.Printf("  Device(s): %s\n", d)
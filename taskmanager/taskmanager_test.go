@@ -0,0 +1,70 @@
+package taskmanager
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/lfkeitel/inca-tool/devices"
+	"github.com/lfkeitel/inca-tool/parser"
+)
+
+var testSelectionInventory = `
+[core] protected=yes
+router1
+router2
+
+[access]
+sw1
+sw2
+sw3
+sw4 protected=maybe
+`
+
+func TestCheckSelection(t *testing.T) {
+	list, err := devices.ParseString(testSelectionInventory)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		forceProtected = false
+		maxDevices = 0
+	}()
+
+	tests := []struct {
+		name       string
+		selection  string
+		force      bool
+		maxPerRun  int
+		taskMax    int32
+		errMessage string
+	}{
+		{"unprotected", "sw1", false, 0, 0, ""},
+		{"protected refused", "core", false, 0, 0, "protected devices router1, router2"},
+		{"protected forced", "core", true, 0, 0, ""},
+		{"invalid protected setting", "sw4", true, 0, 0, "Device sw4: Setting protected must be true or false"},
+		{"under the limit", "sw1 sw2 sw3", false, 3, 0, ""},
+		{"over the limit", "sw1 sw2 sw3", false, 2, 0, "more than the 2 allowed per run"},
+		{"task raises the limit", "sw1 sw2 sw3", false, 2, 3, ""},
+		{"over the task limit", "sw1 sw2 sw3", false, 5, 2, "its max devices is 2"},
+	}
+
+	for _, test := range tests {
+		selected, err := devices.Filter(list, strings.Fields(test.selection))
+		if err != nil {
+			t.Fatal(err)
+		}
+		forceProtected = test.force
+		maxDevices = test.maxPerRun
+
+		err = checkSelection(selected, &parser.TaskFile{MaxDevices: test.taskMax})
+		if test.errMessage == "" {
+			if err != nil {
+				t.Errorf("%s: unexpected error %s", test.name, err.Error())
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), test.errMessage) {
+			t.Errorf("%s: expected an error containing \"%s\", got %v", test.name, test.errMessage, err)
+		}
+	}
+}
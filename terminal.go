@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// resolveAsk resolves "ask" and "ask:label" inventory settings by prompting for the value.
+// The label distinguishes different credentials that use the same setting.
+func resolveAsk(setting, label string) (string, error) {
+	prompt := setting
+	if label != "" {
+		prompt += " (" + label + ")"
+	}
+	return readPassword(prompt + ": ")
+}
+
+// readPassword prompts for a password on the controlling terminal without echoing the input
+func readPassword(prompt string) (string, error) {
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return "", errors.New("No terminal available to read password")
+	}
+	defer tty.Close()
+
+	fmt.Fprint(tty, prompt)
+	if err := setTerminalEcho(tty, false); err != nil {
+		return "", err
+	}
+	defer func() {
+		setTerminalEcho(tty, true)
+		fmt.Fprintln(tty)
+	}()
+
+	line, err := bufio.NewReader(tty).ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// readPasswordConfirm prompts for a password twice and makes sure they match
+func readPasswordConfirm(prompt string) (string, error) {
+	pass, err := readPassword(prompt)
+	if err != nil {
+		return "", err
+	}
+	confirm, err := readPassword("Confirm " + strings.ToLower(prompt[:1]) + prompt[1:])
+	if err != nil {
+		return "", err
+	}
+	if pass != confirm {
+		return "", errors.New("Passwords do not match")
+	}
+	return pass, nil
+}
+
+func setTerminalEcho(tty *os.File, on bool) error {
+	arg := "-echo"
+	if on {
+		arg = "echo"
+	}
+	cmd := exec.Command("stty", arg)
+	cmd.Stdin = tty
+	return cmd.Run()
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/lfkeitel/inca-tool/vault"
+)
+
+var (
+	vaultFile       string // flag
+	vaultKeyFile    string // flag
+	vaultNewKeyFile string // flag
+
+	openedVault *vault.Vault
+)
+
+// resolveVaultSecret resolves "vault:name" inventory settings. The vault is opened on first use.
+func resolveVaultSecret(setting, name string) (string, error) {
+	if openedVault == nil {
+		v, err := openVault()
+		if err != nil {
+			return "", err
+		}
+		openedVault = v
+	}
+
+	secret, ok := openedVault.Get(name)
+	if !ok {
+		return "", fmt.Errorf("Secret %s not found in vault %s", name, vaultFile)
+	}
+	return secret, nil
+}
+
+// runVaultCommand runs the vault subcommand given in args
+func runVaultCommand(args []string) error {
+	if len(args) > 1 {
+		vaultFile = args[1]
+	}
+
+	switch args[0] {
+	case "create":
+		if _, err := os.Stat(vaultFile); err == nil {
+			return fmt.Errorf("Vault %s already exists", vaultFile)
+		}
+		passphrase, err := getVaultPassphrase(vaultKeyFile, true)
+		if err != nil {
+			return err
+		}
+		v := vault.New(vaultFile, passphrase)
+		if err := editVault(v); err != nil {
+			return err
+		}
+		return v.Save()
+
+	case "edit":
+		v, err := openVault()
+		if err != nil {
+			return err
+		}
+		if err := editVault(v); err != nil {
+			return err
+		}
+		return v.Save()
+
+	case "view":
+		v, err := openVault()
+		if err != nil {
+			return err
+		}
+		os.Stdout.Write(v.Text())
+		return nil
+
+	case "rekey":
+		v, err := openVault()
+		if err != nil {
+			return err
+		}
+		var passphrase []byte
+		if vaultNewKeyFile != "" {
+			passphrase, err = vault.ReadKeyFile(vaultNewKeyFile)
+		} else {
+			var pass string
+			pass, err = readPasswordConfirm("New vault passphrase: ")
+			passphrase = []byte(pass)
+		}
+		if err != nil {
+			return err
+		}
+		v.Rekey(passphrase)
+		return v.Save()
+	}
+
+	return fmt.Errorf("Unknown vault command %s", args[0])
+}
+
+func openVault() (*vault.Vault, error) {
+	if _, err := os.Stat(vaultFile); os.IsNotExist(err) {
+		return nil, fmt.Errorf("Vault does not exist: %s", vaultFile)
+	}
+	passphrase, err := getVaultPassphrase(vaultKeyFile, false)
+	if err != nil {
+		return nil, err
+	}
+	return vault.Open(vaultFile, passphrase)
+}
+
+// getVaultPassphrase reads the passphrase from keyFile or prompts for it if no key file is given
+func getVaultPassphrase(keyFile string, confirm bool) ([]byte, error) {
+	if keyFile != "" {
+		return vault.ReadKeyFile(keyFile)
+	}
+
+	var pass string
+	var err error
+	if confirm {
+		pass, err = readPasswordConfirm("Vault passphrase: ")
+	} else {
+		pass, err = readPassword("Vault passphrase: ")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if pass == "" {
+		return nil, errors.New("Vault passphrase cannot be empty")
+	}
+	return []byte(pass), nil
+}
+
+// editVault opens the vault contents in the user's editor. The plaintext is written
+// to a temporary file readable only by the user which is removed afterwards.
+func editVault(v *vault.Vault) error {
+	tmp, err := ioutil.TempFile("", "inca-vault-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	text := v.Text()
+	if len(text) == 0 {
+		text = []byte("# Secrets are given as \"name: value\", one per line\n")
+	}
+	if _, err := tmp.Write(text); err != nil {
+		tmp.Close()
+		return err
+	}
+	tmp.Close()
+
+	editor := os.Getenv("VISUAL")
+	if editor == "" {
+		editor = os.Getenv("EDITOR")
+	}
+	if editor == "" {
+		editor = "vi"
+	}
+	editorArgs := strings.Fields(editor)
+
+	cmd := exec.Command(editorArgs[0], append(editorArgs[1:], tmp.Name())...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("Editor failed: %s", err.Error())
+	}
+
+	text, err = ioutil.ReadFile(tmp.Name())
+	if err != nil {
+		return err
+	}
+	return v.SetText(text)
+}
@@ -0,0 +1,266 @@
+package vault
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+const (
+	fileHeader = "$INCA_VAULT;1;AES256-GCM;PBKDF2-SHA256"
+	iterations = 200000
+	saltSize   = 16
+	keySize    = 32
+	lineWidth  = 76
+
+	// The iteration count is read before the header can be authenticated so a larger
+	// count is refused rather than letting a tampered file hang the tool
+	maxIterations = 10000000
+)
+
+var (
+	// ErrDecrypt is returned when a vault can't be decrypted, usually due to a wrong passphrase
+	ErrDecrypt = errors.New("Failed to decrypt vault, wrong passphrase or key file")
+)
+
+// Vault is a decrypted set of secrets stored in an encrypted file
+type Vault struct {
+	filename   string
+	passphrase []byte
+	secrets    map[string]string
+}
+
+// New creates an empty vault that will be saved to filename using passphrase.
+// The vault isn't written until Save is called.
+func New(filename string, passphrase []byte) *Vault {
+	return &Vault{
+		filename:   filename,
+		passphrase: passphrase,
+		secrets:    make(map[string]string),
+	}
+}
+
+// Open decrypts the vault file filename using passphrase
+func Open(filename string, passphrase []byte) (*Vault, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	plaintext, err := decrypt(data, passphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	v := New(filename, passphrase)
+	if err := v.SetText(plaintext); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// ReadKeyFile reads a key file to use as the passphrase of a vault
+func ReadKeyFile(filename string) ([]byte, error) {
+	key, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	key = bytes.TrimRight(key, "\r\n")
+	if len(key) == 0 {
+		return nil, fmt.Errorf("Key file %s is empty", filename)
+	}
+	return key, nil
+}
+
+// Get returns the secret name and if it exists
+func (v *Vault) Get(name string) (string, bool) {
+	secret, ok := v.secrets[name]
+	return secret, ok
+}
+
+// Set sets the secret name to value
+func (v *Vault) Set(name, value string) {
+	v.secrets[name] = value
+}
+
+// Rekey changes the passphrase used to encrypt the vault. The vault
+// isn't written until Save is called.
+func (v *Vault) Rekey(passphrase []byte) {
+	v.passphrase = passphrase
+}
+
+// Text returns the secrets as "name: value" lines sorted by name
+func (v *Vault) Text() []byte {
+	names := make([]string, 0, len(v.secrets))
+	for name := range v.secrets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	buf := &bytes.Buffer{}
+	for _, name := range names {
+		fmt.Fprintf(buf, "%s: %s\n", name, v.secrets[name])
+	}
+	return buf.Bytes()
+}
+
+// SetText replaces the secrets with those in text. Each line has the form "name: value".
+// Blank lines and lines starting with a pound sign are ignored.
+func (v *Vault) SetText(text []byte) error {
+	secrets := make(map[string]string)
+	scanner := bufio.NewScanner(bytes.NewReader(text))
+	scanner.Split(bufio.ScanLines)
+	lineNum := 0
+
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		lineNum++
+
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+
+		// Split only on the first colon, secrets may contain colons
+		parts := bytes.SplitN(line, []byte(":"), 2)
+		if len(parts) != 2 || len(bytes.TrimSpace(parts[0])) == 0 {
+			return fmt.Errorf("Expected \"name: value\" on line %d of vault", lineNum)
+		}
+		secrets[string(bytes.TrimSpace(parts[0]))] = string(bytes.TrimSpace(parts[1]))
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	v.secrets = secrets
+	return nil
+}
+
+// Save encrypts the vault and writes it to its file. The vault is written to a temporary
+// file that replaces the vault once it's on disk so a failed save can't corrupt the vault.
+// The file is only readable by the user.
+func (v *Vault) Save() error {
+	data, err := encrypt(v.Text(), v.passphrase)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(v.filename), "."+filepath.Base(v.filename))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := tmp.Chmod(0600); err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), v.filename)
+}
+
+func encrypt(plaintext, passphrase []byte) ([]byte, error) {
+	salt := make([]byte, saltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+
+	gcm, err := newGCM(passphrase, salt, iterations)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	// The header is authenticated so it can't be altered
+	header := fileHeader + ";" + strconv.Itoa(iterations)
+	payload := append(salt, nonce...)
+	payload = gcm.Seal(payload, nonce, plaintext, []byte(header))
+
+	encoded := base64.StdEncoding.EncodeToString(payload)
+	buf := &bytes.Buffer{}
+	buf.WriteString(header)
+	buf.WriteString("\n")
+	for len(encoded) > lineWidth {
+		buf.WriteString(encoded[:lineWidth])
+		buf.WriteString("\n")
+		encoded = encoded[lineWidth:]
+	}
+	buf.WriteString(encoded)
+	buf.WriteString("\n")
+	return buf.Bytes(), nil
+}
+
+func decrypt(data, passphrase []byte) ([]byte, error) {
+	lines := strings.SplitN(string(data), "\n", 2)
+	if len(lines) != 2 || !strings.HasPrefix(lines[0], fileHeader+";") {
+		return nil, errors.New("File is not an Inca vault")
+	}
+	header := strings.TrimSpace(lines[0])
+
+	iter, err := strconv.Atoi(strings.TrimPrefix(header, fileHeader+";"))
+	if err != nil || iter <= 0 || iter > maxIterations {
+		return nil, errors.New("Invalid vault header")
+	}
+
+	payload, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(lines[1]), ""))
+	if err != nil {
+		return nil, errors.New("Vault data is corrupt")
+	}
+	if len(payload) < saltSize {
+		return nil, errors.New("Vault data is corrupt")
+	}
+	salt := payload[:saltSize]
+
+	gcm, err := newGCM(passphrase, salt, iter)
+	if err != nil {
+		return nil, err
+	}
+	payload = payload[saltSize:]
+	if len(payload) < gcm.NonceSize() {
+		return nil, errors.New("Vault data is corrupt")
+	}
+
+	plaintext, err := gcm.Open(nil, payload[:gcm.NonceSize()], payload[gcm.NonceSize():], []byte(header))
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	return plaintext, nil
+}
+
+func newGCM(passphrase, salt []byte, iter int) (cipher.AEAD, error) {
+	if len(passphrase) == 0 {
+		return nil, errors.New("Vault passphrase cannot be empty")
+	}
+	key := pbkdf2.Key(passphrase, salt, iter, keySize, sha256.New)
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
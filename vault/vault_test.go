@@ -0,0 +1,137 @@
+package vault
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestVaultRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "inca-vault")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "secrets.vault")
+
+	v := New(filename, []byte("correct horse"))
+	if err := v.SetText([]byte("# Comment\ncore/admin: chicken feet\nedge/admin: pass:word\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err := v.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{"chicken feet", "core/admin"} {
+		if bytes.Contains(data, []byte(secret)) {
+			t.Errorf("vault file contains plaintext \"%s\"", secret)
+		}
+	}
+
+	if _, err := Open(filename, []byte("wrong horse")); err != ErrDecrypt {
+		t.Errorf("expected ErrDecrypt with wrong passphrase, got %v", err)
+	}
+
+	v, err = Open(filename, []byte("correct horse"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s, _ := v.Get("core/admin"); s != "chicken feet" {
+		t.Errorf("incorrect secret core/admin. Expected \"chicken feet\", got \"%s\"", s)
+	}
+	if s, _ := v.Get("edge/admin"); s != "pass:word" {
+		t.Errorf("incorrect secret edge/admin. Expected \"pass:word\", got \"%s\"", s)
+	}
+	if _, ok := v.Get("missing"); ok {
+		t.Error("secret \"missing\" found but should not exist")
+	}
+
+	v.Rekey([]byte("battery staple"))
+	if err := v.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Open(filename, []byte("correct horse")); err != ErrDecrypt {
+		t.Errorf("expected ErrDecrypt with old passphrase, got %v", err)
+	}
+	if _, err := Open(filename, []byte("battery staple")); err != nil {
+		t.Errorf("failed to open rekeyed vault: %s", err.Error())
+	}
+}
+
+func TestVaultSaveReplacesFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "inca-vault")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "secrets.vault")
+
+	// An existing vault readable by others is made private
+	if err := ioutil.WriteFile(filename, []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	v := New(filename, []byte("correct horse"))
+	v.Set("core/admin", "chicken feet")
+	if err := v.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := os.Stat(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("incorrect vault file mode. Expected 0600, got %o", info.Mode().Perm())
+	}
+	files, _ := ioutil.ReadDir(dir)
+	if len(files) != 1 {
+		t.Errorf("temporary files left after saving: %d files in directory", len(files))
+	}
+	if _, err := Open(filename, []byte("correct horse")); err != nil {
+		t.Errorf("failed to open saved vault: %s", err.Error())
+	}
+}
+
+func TestVaultTampered(t *testing.T) {
+	data, err := encrypt([]byte("core/admin: secret\n"), []byte("correct horse"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Flip a bit in the ciphertext
+	tampered := append([]byte{}, data...)
+	tampered[len(tampered)-5] ^= 1
+	if _, err := decrypt(tampered, []byte("correct horse")); err == nil {
+		t.Error("tampered vault decrypted but should have failed")
+	}
+
+	if _, err := decrypt([]byte("core/admin: secret\n"), []byte("correct horse")); err == nil {
+		t.Error("plaintext file decrypted but should have failed")
+	}
+
+	// A huge iteration count is refused before deriving the key
+	hung := bytes.Replace(data, []byte(";200000\n"), []byte(";2000000000\n"), 1)
+	if bytes.Equal(hung, data) {
+		t.Fatal("iteration count not found in header")
+	}
+	done := make(chan error, 1)
+	go func() {
+		_, err := decrypt(hung, []byte("correct horse"))
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err == nil || err == ErrDecrypt {
+			t.Errorf("huge iteration count should be an invalid header, got %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("huge iteration count wasn't refused")
+	}
+}